@@ -29,6 +29,10 @@ type Watcher struct {
 	LoadbalancerImage string
 
 	IngressClass string
+
+	// ingresses are cached to drop the events of secrets no ingress uses
+	ingressStores      []cache.Store
+	ingressControllers []*cache.Controller
 }
 
 func (watch *Watcher) Run() {
@@ -39,6 +43,8 @@ func (watch *Watcher) Run() {
 
 	watch.ExtendedIngress()
 	watch.Ingress()
+	watch.Secret()
+	watch.ConfigMap()
 	watch.Certificate()
 }
 
@@ -109,7 +115,8 @@ func (w *Watcher) Dispatch(e *events.Event) error {
 			certController.Handle(e)
 		}
 	case events.Service:
-		if e.EventType.IsAdded() || e.EventType.IsDeleted() {
+		if e.EventType.IsAdded() || e.EventType.IsDeleted() ||
+			(e.EventType.IsUpdated() && len(e.RuntimeObj) > 1 && ingresscontroller.IsExternalNameChanged(e.RuntimeObj[0], e.RuntimeObj[1])) {
 			return ingresscontroller.UpgradeAllEngress(
 				e.MetaData.Name+"."+e.MetaData.Namespace,
 				w.ClusterName,
@@ -118,6 +125,32 @@ func (w *Watcher) Dispatch(e *events.Event) error {
 				w.AppsCodeExtensionClient,
 				w.Storage, w.IngressClass)
 		}
+	case events.Secret:
+		if !w.isSecretUsed(e.MetaData.Name, e.MetaData.Namespace) {
+			return nil
+		}
+		return ingresscontroller.UpgradeAllEngressForSecret(
+			e.MetaData.Name,
+			e.MetaData.Namespace,
+			e.EventType,
+			w.ClusterName,
+			w.ProviderName,
+			w.Client,
+			w.AppsCodeExtensionClient,
+			w.Storage, w.IngressClass)
+	case events.ConfigMap:
+		if !w.isConfigMapUsed(e.MetaData.Name, e.MetaData.Namespace) {
+			return nil
+		}
+		return ingresscontroller.UpgradeAllEngressForConfigMap(
+			e.MetaData.Name,
+			e.MetaData.Namespace,
+			e.EventType,
+			w.ClusterName,
+			w.ProviderName,
+			w.Client,
+			w.AppsCodeExtensionClient,
+			w.Storage, w.IngressClass)
 	case events.Endpoint:
 		// Checking if this endpoint have a service or not. If
 		// this do not have a Service we do not want to update our ingress
@@ -152,3 +185,86 @@ func (w *Watcher) Certificate() {
 
 	go certificates.NewCertificateSyncer(w.Client, w.AppsCodeExtensionClient).RunSync()
 }
+
+func (w *Watcher) Ingress() {
+	log.Debugln("watching", events.Ingress.String())
+	lw := &cache.ListWatch{
+		ListFunc:  acw.IngressListFunc(w.Client),
+		WatchFunc: acw.IngressWatchFunc(w.Client),
+	}
+	store, controller := w.Cache(events.Ingress, &extensions.Ingress{}, lw)
+	w.ingressStores = append(w.ingressStores, store)
+	w.ingressControllers = append(w.ingressControllers, controller)
+	go controller.Run(wait.NeverStop)
+}
+
+func (w *Watcher) ExtendedIngress() {
+	log.Debugln("watching", events.ExtendedIngress.String())
+	lw := &cache.ListWatch{
+		ListFunc:  acw.ExtendedIngressListFunc(w.AppsCodeExtensionClient),
+		WatchFunc: acw.ExtendedIngressWatchFunc(w.AppsCodeExtensionClient),
+	}
+	store, controller := w.Cache(events.ExtendedIngress, &aci.Ingress{}, lw)
+	w.ingressStores = append(w.ingressStores, store)
+	w.ingressControllers = append(w.ingressControllers, controller)
+	go controller.Run(wait.NeverStop)
+}
+
+// isSecretUsed checks the cached ingresses of the namespace of a secret for
+// one using it. Every secret is used until the caches are synced.
+func (w *Watcher) isSecretUsed(name, namespace string) bool {
+	return w.isUsed(namespace, func(engress *aci.Ingress) bool {
+		return ingresscontroller.IsSecretUsed(engress, name)
+	})
+}
+
+// isConfigMapUsed checks the cached ingresses of the namespace of a ConfigMap
+// for one serving error pages from it.
+func (w *Watcher) isConfigMapUsed(name, namespace string) bool {
+	return w.isUsed(namespace, func(engress *aci.Ingress) bool {
+		return ingresscontroller.IsConfigMapUsed(engress, name)
+	})
+}
+
+func (w *Watcher) isUsed(namespace string, used func(*aci.Ingress) bool) bool {
+	for _, controller := range w.ingressControllers {
+		if !controller.HasSynced() {
+			return true
+		}
+	}
+	for _, store := range w.ingressStores {
+		for _, obj := range store.List() {
+			engress, ok := obj.(*aci.Ingress)
+			if !ok {
+				var err error
+				if engress, err = aci.NewEngressFromIngress(obj); err != nil {
+					continue
+				}
+			}
+			if engress.Namespace == namespace && used(engress) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (w *Watcher) Secret() {
+	log.Debugln("watching", events.Secret.String())
+	lw := &cache.ListWatch{
+		ListFunc:  acw.SecretListFunc(w.Client),
+		WatchFunc: acw.SecretWatchFunc(w.Client),
+	}
+	_, controller := w.Cache(events.Secret, &kapi.Secret{}, lw)
+	go controller.Run(wait.NeverStop)
+}
+
+func (w *Watcher) ConfigMap() {
+	log.Debugln("watching", events.ConfigMap.String())
+	lw := &cache.ListWatch{
+		ListFunc:  acw.ConfigMapListFunc(w.Client),
+		WatchFunc: acw.ConfigMapWatchFunc(w.Client),
+	}
+	_, controller := w.Cache(events.ConfigMap, &kapi.ConfigMap{}, lw)
+	go controller.Run(wait.NeverStop)
+}
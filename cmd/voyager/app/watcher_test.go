@@ -9,6 +9,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	kapi "k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/cache"
 	clientset "k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/fake"
 )
 
@@ -48,3 +49,39 @@ func TestEnsureResource(t *testing.T) {
 	_, err = w.Client.Extensions().ThirdPartyResources().Get("certificate." + aci.V1beta1SchemeGroupVersion.Group)
 	assert.Nil(t, err)
 }
+
+func TestIsSecretUsed(t *testing.T) {
+	ingresses := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ingresses.Add(&extensions.Ingress{
+		ObjectMeta: kapi.ObjectMeta{Name: "web", Namespace: "default"},
+	})
+	engresses := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	engresses.Add(&aci.Ingress{
+		ObjectMeta: kapi.ObjectMeta{Name: "shop", Namespace: "default"},
+		Spec: aci.ExtendedIngressSpec{
+			Rules: []aci.ExtendedIngressRule{
+				{BasicAuth: &aci.BasicAuth{SecretName: "shop-auth"}},
+			},
+		},
+	})
+	w := &Watcher{ingressStores: []cache.Store{ingresses, engresses}}
+
+	assert.True(t, w.isSecretUsed("shop-auth", "default"))
+	assert.False(t, w.isSecretUsed("shop-auth", "other"))
+	assert.False(t, w.isSecretUsed("default-token-x1y2z", "default"))
+}
+
+func TestIsConfigMapUsed(t *testing.T) {
+	engresses := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	engresses.Add(&aci.Ingress{
+		ObjectMeta: kapi.ObjectMeta{Name: "shop", Namespace: "default"},
+		Spec: aci.ExtendedIngressSpec{
+			Backend: &aci.ExtendedIngressBackend{ErrorFiles: "error-pages"},
+		},
+	})
+	w := &Watcher{ingressStores: []cache.Store{engresses}}
+
+	assert.True(t, w.isConfigMapUsed("error-pages", "default"))
+	assert.False(t, w.isConfigMapUsed("error-pages", "other"))
+	assert.False(t, w.isConfigMapUsed("kube-proxy", "default"))
+}
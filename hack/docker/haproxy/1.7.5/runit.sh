@@ -23,5 +23,20 @@ do
 	cat $dir/tls.key >> $CERT_DIR/$secret.pem
 done
 
+# client certificates presented to backends with upstream TLS
+UPSTREAM_CERT_DIR=/etc/ssl/private/upstream
+mkdir -p $UPSTREAM_CERT_DIR
+
+for dir in /srv/haproxy/upstream/*/
+do
+	dir=${dir%*/}
+	secret=${dir##*/}
+
+	if [ -f $dir/tls.crt ] && [ -f $dir/tls.key ]; then
+		cat $dir/tls.crt >  $UPSTREAM_CERT_DIR/$secret.pem
+		cat $dir/tls.key >> $UPSTREAM_CERT_DIR/$secret.pem
+	fi
+done
+
 echo "Starting runit..."
 exec /usr/sbin/runsvdir-start
@@ -362,6 +362,22 @@ func (lbc *EngressController) createNodePortPods() error {
 	return nil
 }
 
+// setProxyProtocolAnnotation asks the AWS ELB to send a PROXY protocol header
+// to all ports of the service if the HAProxy binds expect it.
+func (lbc *EngressController) setProxyProtocolAnnotation(svc *kapi.Service) {
+	if lbc.Options.ProviderName != "aws" {
+		return
+	}
+	if svc.Annotations == nil {
+		svc.Annotations = make(map[string]string)
+	}
+	if lbc.Options.AcceptProxy {
+		svc.Annotations[awsProxyProtocolAnnotation] = "*"
+	} else {
+		delete(svc.Annotations, awsProxyProtocolAnnotation)
+	}
+}
+
 func (lbc *EngressController) createLoadBalancerSvc() error {
 	log.Infoln("creating LoadBalancer type lb")
 	// creating service as typeLoadBalancer
@@ -405,6 +421,7 @@ func (lbc *EngressController) createLoadBalancerSvc() error {
 	case "minikube":
 		svc.Spec.Type = kapi.ServiceTypeLoadBalancer
 	}
+	lbc.setProxyProtocolAnnotation(svc)
 
 	svc, err := lbc.KubeClient.Core().Services(lbc.Config.Namespace).Create(svc)
 	if err != nil {
@@ -517,6 +534,53 @@ func Volumes(o *KubeOptions) []kapi.Volume {
 		}
 		vs = append(vs, sVolume)
 	}
+	for _, c := range o.ErrorFilesConfigMaps {
+		if _, ok := skipper[c+"-configmap-volume"]; ok {
+			continue
+		}
+		skipper[c+"-configmap-volume"] = true
+		cVolume := kapi.Volume{
+			Name: c + "-configmap-volume",
+			VolumeSource: kapi.VolumeSource{
+				ConfigMap: &kapi.ConfigMapVolumeSource{
+					LocalObjectReference: kapi.LocalObjectReference{
+						Name: c,
+					},
+				},
+			},
+		}
+		vs = append(vs, cVolume)
+	}
+	for _, s := range o.UpstreamTLSSecrets {
+		if _, ok := skipper[s+"-upstream-volume"]; ok {
+			continue
+		}
+		skipper[s+"-upstream-volume"] = true
+		uVolume := kapi.Volume{
+			Name: s + "-upstream-volume",
+			VolumeSource: kapi.VolumeSource{
+				Secret: &kapi.SecretVolumeSource{
+					SecretName: s,
+				},
+			},
+		}
+		vs = append(vs, uVolume)
+	}
+	for _, s := range o.ClientCASecrets {
+		if _, ok := skipper[s+"-client-ca-volume"]; ok {
+			continue
+		}
+		skipper[s+"-client-ca-volume"] = true
+		caVolume := kapi.Volume{
+			Name: s + "-client-ca-volume",
+			VolumeSource: kapi.VolumeSource{
+				Secret: &kapi.SecretVolumeSource{
+					SecretName: s,
+				},
+			},
+		}
+		vs = append(vs, caVolume)
+	}
 	return vs
 }
 
@@ -537,5 +601,38 @@ func VolumeMounts(o *KubeOptions) []kapi.VolumeMount {
 		}
 		ms = append(ms, sMount)
 	}
+	for _, c := range o.ErrorFilesConfigMaps {
+		if _, ok := skipper[c+"-configmap-volume"]; ok {
+			continue
+		}
+		skipper[c+"-configmap-volume"] = true
+		cMount := kapi.VolumeMount{
+			Name:      c + "-configmap-volume",
+			MountPath: errorFilesPath + c,
+		}
+		ms = append(ms, cMount)
+	}
+	for _, s := range o.UpstreamTLSSecrets {
+		if _, ok := skipper[s+"-upstream-volume"]; ok {
+			continue
+		}
+		skipper[s+"-upstream-volume"] = true
+		uMount := kapi.VolumeMount{
+			Name:      s + "-upstream-volume",
+			MountPath: upstreamSecretsPath + s,
+		}
+		ms = append(ms, uMount)
+	}
+	for _, s := range o.ClientCASecrets {
+		if _, ok := skipper[s+"-client-ca-volume"]; ok {
+			continue
+		}
+		skipper[s+"-client-ca-volume"] = true
+		caMount := kapi.VolumeMount{
+			Name:      s + "-client-ca-volume",
+			MountPath: clientCASecretsPath + s,
+		}
+		ms = append(ms, caMount)
+	}
 	return ms
 }
@@ -3,6 +3,7 @@ package ingress
 import (
 	"encoding/json"
 	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/appscode/errors"
@@ -70,29 +71,10 @@ func UpgradeAllEngress(service, clusterName, providerName string,
 	acExtClient acs.AppsCodeExtensionInterface,
 	store *stash.Storage,
 	ingressClass string) error {
-	ing, err := kubeClient.Extensions().Ingresses(kapi.NamespaceAll).List(kapi.ListOptions{
-		LabelSelector: labels.Everything(),
-	})
+	items, err := listEngress(kubeClient, acExtClient, kapi.NamespaceAll)
 	if err != nil {
 		return errors.FromErr(err).Err()
 	}
-
-	eng, err := acExtClient.Ingress(kapi.NamespaceAll).List(kapi.ListOptions{
-		LabelSelector: labels.Everything(),
-	})
-	if err != nil {
-		return errors.FromErr(err).Err()
-	}
-
-	items := make([]aci.Ingress, len(ing.Items))
-	for i, item := range ing.Items {
-		e, err := aci.NewEngressFromIngress(item)
-		if err != nil {
-			continue
-		}
-		items[i] = *e
-	}
-	items = append(items, eng.Items...)
 	log.Infoln("Updating All Ingress, got total", len(items))
 	for i, item := range items {
 		engress := &items[i]
@@ -131,6 +113,125 @@ func UpgradeAllEngress(service, clusterName, providerName string,
 	return nil
 }
 
+// UpgradeAllEngressForSecret updates the HAProxy config of all ingresses in
+// the namespace of a secret that render the secret into their config. Ingresses
+// using the secret for upstream TLS or client auth are restarted instead, as
+// the certificates are loaded when the HAProxy pods start.
+func UpgradeAllEngressForSecret(secretName, namespace string, eventType events.EventType,
+	clusterName, providerName string,
+	kubeClient clientset.Interface,
+	acExtClient acs.AppsCodeExtensionInterface,
+	store *stash.Storage,
+	ingressClass string) error {
+	items, err := listEngress(kubeClient, acExtClient, namespace)
+	if err != nil {
+		return errors.FromErr(err).Err()
+	}
+	for i := range items {
+		engress := &items[i]
+		if !shouldHandleIngress(engress, ingressClass) {
+			continue
+		}
+		if stringutil.Contains(upstreamTLSSecrets(engress), secretName) || stringutil.Contains(clientAuthSecrets(engress), secretName) {
+			lbc := NewEngressController(clusterName, providerName, kubeClient, acExtClient, store, ingressClass)
+			lbc.Config = engress
+			if !lbc.IsExists() {
+				continue
+			}
+			// Secrets are listed as added on every start of voyager, restart
+			// only if the secret appears in or vanishes from the config.
+			if !eventType.IsUpdated() && !lbc.isConfigChanged() {
+				continue
+			}
+			log.Infoln("Secret", secretName, "changed, trying to restart Ingress", engress.Name, engress.Namespace)
+			err := lbc.Update(RestartHAProxy)
+			if err != nil {
+				log.Errorln("Failed to update Ingress", engress.Name, engress.Namespace, "cause", err)
+			}
+		} else if isEngressHaveSecret(engress, secretName) {
+			lbc := NewEngressController(clusterName, providerName, kubeClient, acExtClient, store, ingressClass)
+			lbc.Config = engress
+			if !lbc.IsExists() {
+				continue
+			}
+			// the users of the secret are rendered into the config
+			if !eventType.IsUpdated() && !lbc.isConfigChanged() {
+				continue
+			}
+			log.Infoln("Secret", secretName, "changed, trying to update Ingress", engress.Name, engress.Namespace)
+			err := lbc.Update(UpdateConfig)
+			if err != nil {
+				log.Errorln("Failed to update Ingress", engress.Name, engress.Namespace, "cause", err)
+			}
+		}
+	}
+	return nil
+}
+
+// UpgradeAllEngressForConfigMap restarts HAProxy of all ingresses in the
+// namespace of a ConfigMap that use its error pages, as HAProxy reads error
+// files only on start and the ConfigMap has to be mounted in the pods.
+func UpgradeAllEngressForConfigMap(configMapName, namespace string, eventType events.EventType,
+	clusterName, providerName string,
+	kubeClient clientset.Interface,
+	acExtClient acs.AppsCodeExtensionInterface,
+	store *stash.Storage,
+	ingressClass string) error {
+	items, err := listEngress(kubeClient, acExtClient, namespace)
+	if err != nil {
+		return errors.FromErr(err).Err()
+	}
+	for i := range items {
+		engress := &items[i]
+		if shouldHandleIngress(engress, ingressClass) && IsConfigMapUsed(engress, configMapName) {
+			lbc := NewEngressController(clusterName, providerName, kubeClient, acExtClient, store, ingressClass)
+			lbc.Config = engress
+			if !lbc.IsExists() {
+				continue
+			}
+			// ConfigMaps are listed as added on every start of voyager, restart
+			// only if error files appear or vanish from the config.
+			if !eventType.IsUpdated() && !lbc.isConfigChanged() {
+				continue
+			}
+			log.Infoln("ConfigMap", configMapName, "changed, trying to restart Ingress", engress.Name, engress.Namespace)
+			err := lbc.Update(RestartHAProxy)
+			if err != nil {
+				log.Errorln("Failed to update Ingress", engress.Name, engress.Namespace, "cause", err)
+			}
+		}
+	}
+	return nil
+}
+
+// listEngress returns all ingresses and extended ingresses of a namespace
+// as extended ingresses.
+func listEngress(kubeClient clientset.Interface, acExtClient acs.AppsCodeExtensionInterface, namespace string) ([]aci.Ingress, error) {
+	ing, err := kubeClient.Extensions().Ingresses(namespace).List(kapi.ListOptions{
+		LabelSelector: labels.Everything(),
+	})
+	if err != nil {
+		return nil, errors.FromErr(err).Err()
+	}
+
+	eng, err := acExtClient.Ingress(namespace).List(kapi.ListOptions{
+		LabelSelector: labels.Everything(),
+	})
+	if err != nil {
+		return nil, errors.FromErr(err).Err()
+	}
+
+	items := make([]aci.Ingress, len(ing.Items))
+	for i, item := range ing.Items {
+		e, err := aci.NewEngressFromIngress(item)
+		if err != nil {
+			continue
+		}
+		items[i] = *e
+	}
+	return append(items, eng.Items...), nil
+}
+
 func (lbc *EngressController) Handle(e *events.Event) error {
 	log.Infof("Engress event %s/%s occurred for %s", e.EventType, e.ResourceType, e.MetaData.Name)
 	// convert to extended ingress and then handle
@@ -179,15 +280,16 @@ func (lbc *EngressController) Handle(e *events.Event) error {
 			lbc.Delete()
 		}
 	} else if e.EventType.IsUpdated() {
-		if reflect.DeepEqual(engs[0].(*aci.Ingress).Spec, engs[1].(*aci.Ingress).Spec) {
+		if reflect.DeepEqual(engs[0].(*aci.Ingress).Spec, engs[1].(*aci.Ingress).Spec) &&
+			reflect.DeepEqual(engs[0].(*aci.Ingress).Annotations, engs[1].(*aci.Ingress).Annotations) {
 			return nil
 		}
 
 		lbc.Config = engs[1].(*aci.Ingress)
 		if shouldHandleIngress(lbc.Config, lbc.IngressClass) {
-			if isNewPortOpened(engs[0], engs[1]) {
+			if isNewPortOpened(engs[0], engs[1]) || isAcceptProxyChanged(engs[0], engs[1]) {
 				lbc.Update(UpdateFirewall)
-			} else if isNewSecretAdded(engs[0], engs[1]) {
+			} else if isNewSecretAdded(engs[0], engs[1]) || isErrorFilesChanged(engs[0], engs[1]) || isUpstreamTLSChanged(engs[0], engs[1]) || isClientAuthChanged(engs[0], engs[1]) {
 				lbc.Update(RestartHAProxy)
 			} else {
 				lbc.Update(UpdateConfig)
@@ -296,18 +398,57 @@ func ensureServiceAnnotations(client clientset.Interface, ing *aci.Ingress, name
 	}
 }
 
+// IsExternalNameChanged checks whether an ExternalName service points to
+// another host or port. It has no Endpoints object, so its updates are the
+// only way to learn about that.
+func IsExternalNameChanged(old interface{}, new interface{}) bool {
+	o, ok := old.(*kapi.Service)
+	if !ok {
+		return false
+	}
+	n, ok := new.(*kapi.Service)
+	if !ok {
+		return false
+	}
+	if o.Spec.Type != kapi.ServiceTypeExternalName && n.Spec.Type != kapi.ServiceTypeExternalName {
+		return false
+	}
+	return o.Spec.Type != n.Spec.Type ||
+		o.Spec.ExternalName != n.Spec.ExternalName ||
+		!reflect.DeepEqual(o.Spec.Ports, n.Spec.Ports)
+}
+
+// isAcceptProxyChanged checks whether the LoadBalancer has to start or stop
+// sending PROXY protocol headers.
+func isAcceptProxyChanged(old interface{}, new interface{}) bool {
+	o := old.(*aci.Ingress)
+	n := new.(*aci.Ingress)
+	return annotation(o.Annotations).AcceptProxy() != annotation(n.Annotations).AcceptProxy()
+}
+
 func isNewPortOpened(old interface{}, new interface{}) bool {
 	o := old.(*aci.Ingress)
 	n := new.(*aci.Ingress)
 
+	// redirecting http to https may need port 80 to be opened.
+	if !annotation(o.Annotations).SSLRedirect() && annotation(n.Annotations).SSLRedirect() {
+		return true
+	}
+
 	oldPortLists := make([]string, 0)
 	for _, rs := range o.Spec.Rules {
+		if rs.HTTP != nil && rs.Port.IntValue() > 0 {
+			oldPortLists = append(oldPortLists, rs.Port.String())
+		}
 		for _, port := range rs.TCP {
 			oldPortLists = append(oldPortLists, port.Port.String())
 		}
 	}
 
 	for _, rs := range n.Spec.Rules {
+		if rs.HTTP != nil && rs.Port.IntValue() > 0 && !stringutil.Contains(oldPortLists, rs.Port.String()) {
+			return true
+		}
 		for _, port := range rs.TCP {
 			if !stringutil.Contains(oldPortLists, port.Port.String()) {
 				return true
@@ -361,11 +502,17 @@ func isEngressHaveService(ing *aci.Ingress, service string) (bool, string, strin
 
 	for _, rules := range ing.Spec.Rules {
 		if rules.HTTP != nil {
-			for _, svc := range rules.HTTP.Paths {
+			for _, svc := range rulePaths(rules) {
 				if svc.Backend.ServiceName == service || svc.Backend.ServiceName == serviceNotWithDefault {
 					name, namespace := splitNameNamespace(service, serviceNotWithDefault, ing.Namespace)
 					return true, name, namespace
 				}
+				for _, b := range svc.Backends {
+					if b.ServiceName == service || b.ServiceName == serviceNotWithDefault {
+						name, namespace := splitNameNamespace(service, serviceNotWithDefault, ing.Namespace)
+						return true, name, namespace
+					}
+				}
 			}
 		}
 
@@ -379,6 +526,133 @@ func isEngressHaveService(ing *aci.Ingress, service string) (bool, string, strin
 	return false, "", ""
 }
 
+// IsSecretUsed checks whether an ingress uses a secret for basic auth,
+// upstream TLS or client auth, so its changes need to reach HAProxy.
+func IsSecretUsed(ing *aci.Ingress, secretName string) bool {
+	return isEngressHaveSecret(ing, secretName) ||
+		stringutil.Contains(upstreamTLSSecrets(ing), secretName) ||
+		stringutil.Contains(clientAuthSecrets(ing), secretName)
+}
+
+// isEngressHaveSecret checks whether a secret of the ingress namespace is
+// rendered into the HAProxy config of the ingress.
+func isEngressHaveSecret(ing *aci.Ingress, secretName string) bool {
+	for _, rule := range ing.Spec.Rules {
+		if rule.BasicAuth != nil && rule.BasicAuth.SecretName == secretName {
+			return true
+		}
+		if rule.HTTP != nil {
+			for _, path := range rule.HTTP.Paths {
+				if path.BasicAuth != nil && path.BasicAuth.SecretName == secretName {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// errorFilesConfigMaps returns the sorted names of all ConfigMaps the ingress
+// uses for error pages.
+func errorFilesConfigMaps(ing *aci.Ingress) []string {
+	names := make([]string, 0)
+	add := func(name string) {
+		if name != "" && !stringutil.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	add(annotation(ing.Annotations).ErrorFiles())
+	add(annotation(ing.Annotations).NoRouteErrorFiles())
+	if ing.Spec.Backend != nil {
+		add(ing.Spec.Backend.ErrorFiles)
+	}
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP != nil {
+			for _, path := range rulePaths(rule) {
+				add(path.Backend.ErrorFiles)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsConfigMapUsed checks whether an ingress serves error pages from a
+// ConfigMap, so its changes need to reach HAProxy.
+func IsConfigMapUsed(ing *aci.Ingress, configMapName string) bool {
+	return stringutil.Contains(errorFilesConfigMaps(ing), configMapName)
+}
+
+// isErrorFilesChanged checks whether the ConfigMaps mounted for error pages
+// differ, which needs the HAProxy pods to be recreated.
+func isErrorFilesChanged(old interface{}, new interface{}) bool {
+	return !reflect.DeepEqual(errorFilesConfigMaps(old.(*aci.Ingress)), errorFilesConfigMaps(new.(*aci.Ingress)))
+}
+
+// upstreamTLSSecrets returns the sorted names of all Secrets the ingress uses
+// for TLS connections to backend servers.
+func upstreamTLSSecrets(ing *aci.Ingress) []string {
+	names := make([]string, 0)
+	add := func(tls *aci.UpstreamTLS) {
+		if tls == nil {
+			return
+		}
+		for _, name := range []string{tls.CASecretName, tls.ClientSecretName} {
+			if name != "" && !stringutil.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	if ing.Spec.Backend != nil {
+		add(ing.Spec.Backend.UpstreamTLS)
+	}
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP != nil {
+			for _, path := range rulePaths(rule) {
+				add(path.Backend.UpstreamTLS)
+			}
+		}
+		for _, tcp := range rule.TCP {
+			add(tcp.Backend.UpstreamTLS)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isUpstreamTLSChanged checks whether the Secrets mounted for upstream TLS
+// differ, which needs the HAProxy pods to be recreated.
+func isUpstreamTLSChanged(old interface{}, new interface{}) bool {
+	return !reflect.DeepEqual(upstreamTLSSecrets(old.(*aci.Ingress)), upstreamTLSSecrets(new.(*aci.Ingress)))
+}
+
+// clientAuthSecrets returns the sorted names of all Secrets the ingress uses
+// to verify client certificates.
+func clientAuthSecrets(ing *aci.Ingress) []string {
+	names := make([]string, 0)
+	add := func(auth *aci.ClientAuth) {
+		if auth != nil && auth.CASecretName != "" && !stringutil.Contains(names, auth.CASecretName) {
+			names = append(names, auth.CASecretName)
+		}
+	}
+	for _, tls := range ing.Spec.TLS {
+		add(tls.ClientAuth)
+	}
+	for _, rule := range ing.Spec.Rules {
+		for _, tcp := range rule.TCP {
+			add(tcp.ClientAuth)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isClientAuthChanged checks whether the Secrets mounted for client auth
+// differ, which needs the HAProxy pods to be recreated.
+func isClientAuthChanged(old interface{}, new interface{}) bool {
+	return !reflect.DeepEqual(clientAuthSecrets(old.(*aci.Ingress)), clientAuthSecrets(new.(*aci.Ingress)))
+}
+
 func splitNameNamespace(fqdn, name, namespace string) (string, string) {
 	if fqdn == (name+"."+namespace) || fqdn == name {
 		return name, namespace
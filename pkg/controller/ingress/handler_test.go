@@ -9,6 +9,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	kapi "k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/fake"
+	"k8s.io/kubernetes/pkg/util/intstr"
 )
 
 func init() {
@@ -128,3 +129,254 @@ func TestEnsureServiceAnnotation(t *testing.T) {
 	assert.Contains(t, svc.Annotations, aci.EngressKey)
 	fmt.Println(svc.Annotations)
 }
+
+func TestIsNewPortOpened(t *testing.T) {
+	old := &aci.Ingress{}
+	assert.False(t, isNewPortOpened(old, &aci.Ingress{}))
+
+	assert.True(t, isNewPortOpened(old, &aci.Ingress{
+		ObjectMeta: kapi.ObjectMeta{
+			Annotations: map[string]string{
+				SSLRedirect: "true",
+			},
+		},
+	}))
+
+	assert.True(t, isNewPortOpened(old, &aci.Ingress{
+		Spec: aci.ExtendedIngressSpec{
+			Rules: []aci.ExtendedIngressRule{
+				{
+					ExtendedIngressRuleValue: aci.ExtendedIngressRuleValue{
+						TCP: []aci.TCPExtendedIngressRuleValue{
+							{Port: intstr.FromInt(5432)},
+						},
+					},
+				},
+			},
+		},
+	}))
+
+	assert.True(t, isNewPortOpened(old, &aci.Ingress{
+		Spec: aci.ExtendedIngressSpec{
+			Rules: []aci.ExtendedIngressRule{
+				{
+					Port: intstr.FromInt(8080),
+					ExtendedIngressRuleValue: aci.ExtendedIngressRuleValue{
+						HTTP: &aci.HTTPExtendedIngressRuleValue{},
+					},
+				},
+			},
+		},
+	}))
+}
+
+func TestIsEngressHaveSecret(t *testing.T) {
+	ing := &aci.Ingress{
+		Spec: aci.ExtendedIngressSpec{
+			Rules: []aci.ExtendedIngressRule{
+				{
+					BasicAuth: &aci.BasicAuth{SecretName: "host-auth"},
+				},
+				{
+					ExtendedIngressRuleValue: aci.ExtendedIngressRuleValue{
+						HTTP: &aci.HTTPExtendedIngressRuleValue{
+							Paths: []aci.HTTPExtendedIngressPath{
+								{
+									BasicAuth: &aci.BasicAuth{SecretName: "path-auth"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	assert.True(t, isEngressHaveSecret(ing, "host-auth"))
+	assert.True(t, isEngressHaveSecret(ing, "path-auth"))
+	assert.False(t, isEngressHaveSecret(ing, "other"))
+}
+
+func TestIsSecretUsed(t *testing.T) {
+	ing := &aci.Ingress{
+		Spec: aci.ExtendedIngressSpec{
+			TLS: []aci.ExtendedIngressTLS{
+				{ClientAuth: &aci.ClientAuth{CASecretName: "client-ca"}},
+			},
+			Rules: []aci.ExtendedIngressRule{
+				{
+					BasicAuth: &aci.BasicAuth{SecretName: "host-auth"},
+				},
+				{
+					ExtendedIngressRuleValue: aci.ExtendedIngressRuleValue{
+						TCP: []aci.TCPExtendedIngressRuleValue{
+							{
+								Backend: aci.IngressBackend{UpstreamTLS: &aci.UpstreamTLS{CASecretName: "upstream-ca"}},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	assert.True(t, IsSecretUsed(ing, "host-auth"))
+	assert.True(t, IsSecretUsed(ing, "client-ca"))
+	assert.True(t, IsSecretUsed(ing, "upstream-ca"))
+	assert.False(t, IsSecretUsed(ing, "other"))
+}
+
+func TestIsErrorFilesChanged(t *testing.T) {
+	old := &aci.Ingress{
+		ObjectMeta: kapi.ObjectMeta{
+			Annotations: map[string]string{
+				ErrorFiles: "error-pages",
+			},
+		},
+	}
+	new := &aci.Ingress{
+		ObjectMeta: kapi.ObjectMeta{
+			Annotations: map[string]string{
+				ErrorFiles: "error-pages",
+			},
+		},
+		Spec: aci.ExtendedIngressSpec{
+			Backend: &aci.ExtendedIngressBackend{
+				ErrorFiles: "error-pages",
+			},
+		},
+	}
+	assert.False(t, isErrorFilesChanged(old, new))
+	assert.True(t, IsConfigMapUsed(new, "error-pages"))
+
+	new.Spec.Backend.ErrorFiles = "api-error-pages"
+	assert.True(t, isErrorFilesChanged(old, new))
+	assert.Equal(t, []string{"api-error-pages", "error-pages"}, errorFilesConfigMaps(new))
+}
+
+func TestIsUpstreamTLSChanged(t *testing.T) {
+	old := &aci.Ingress{
+		Spec: aci.ExtendedIngressSpec{
+			Backend: &aci.ExtendedIngressBackend{
+				UpstreamTLS: &aci.UpstreamTLS{CASecretName: "upstream-ca"},
+			},
+		},
+	}
+	new := &aci.Ingress{
+		Spec: aci.ExtendedIngressSpec{
+			Backend: &aci.ExtendedIngressBackend{
+				UpstreamTLS: &aci.UpstreamTLS{CASecretName: "upstream-ca"},
+			},
+			Rules: []aci.ExtendedIngressRule{
+				{
+					ExtendedIngressRuleValue: aci.ExtendedIngressRuleValue{
+						TCP: []aci.TCPExtendedIngressRuleValue{
+							{
+								Backend: aci.IngressBackend{
+									UpstreamTLS: &aci.UpstreamTLS{CASecretName: "upstream-ca"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	assert.False(t, isUpstreamTLSChanged(old, new))
+
+	new.Spec.Rules[0].TCP[0].Backend.UpstreamTLS.ClientSecretName = "db-client"
+	assert.True(t, isUpstreamTLSChanged(old, new))
+	assert.Equal(t, []string{"db-client", "upstream-ca"}, upstreamTLSSecrets(new))
+}
+
+func TestIsClientAuthChanged(t *testing.T) {
+	old := &aci.Ingress{
+		Spec: aci.ExtendedIngressSpec{
+			TLS: []aci.ExtendedIngressTLS{
+				{Hosts: []string{"api.example.com"}, ClientAuth: &aci.ClientAuth{CASecretName: "partner-ca"}},
+			},
+		},
+	}
+	new := &aci.Ingress{
+		Spec: aci.ExtendedIngressSpec{
+			TLS: []aci.ExtendedIngressTLS{
+				{Hosts: []string{"api.example.com"}, ClientAuth: &aci.ClientAuth{CASecretName: "partner-ca", Verify: "optional"}},
+			},
+		},
+	}
+	assert.False(t, isClientAuthChanged(old, new))
+
+	new.Spec.TLS[0].ClientAuth.CASecretName = "internal-ca"
+	assert.True(t, isClientAuthChanged(old, new))
+	assert.Equal(t, []string{"internal-ca"}, clientAuthSecrets(new))
+}
+
+func TestIsAcceptProxyChanged(t *testing.T) {
+	old := &aci.Ingress{}
+	new := &aci.Ingress{
+		ObjectMeta: kapi.ObjectMeta{
+			Annotations: map[string]string{AcceptProxy: "true"},
+		},
+	}
+	assert.True(t, isAcceptProxyChanged(old, new))
+	assert.False(t, isAcceptProxyChanged(new, new))
+
+	lbc := &EngressController{Options: &KubeOptions{ProviderName: "aws", AcceptProxy: true}}
+	svc := &kapi.Service{}
+	lbc.setProxyProtocolAnnotation(svc)
+	assert.Equal(t, "*", svc.Annotations[awsProxyProtocolAnnotation])
+
+	lbc.Options.AcceptProxy = false
+	lbc.setProxyProtocolAnnotation(svc)
+	assert.NotContains(t, svc.Annotations, awsProxyProtocolAnnotation)
+}
+
+func TestIsExternalNameChanged(t *testing.T) {
+	old := &kapi.Service{
+		Spec: kapi.ServiceSpec{
+			Type:         kapi.ServiceTypeExternalName,
+			ExternalName: "legacy.example.com",
+		},
+	}
+	new := &kapi.Service{
+		Spec: kapi.ServiceSpec{
+			Type:         kapi.ServiceTypeExternalName,
+			ExternalName: "legacy.example.com",
+		},
+	}
+	assert.False(t, IsExternalNameChanged(old, new))
+
+	new.Spec.ExternalName = "new.example.com"
+	assert.True(t, IsExternalNameChanged(old, new))
+
+	assert.False(t, IsExternalNameChanged(&kapi.Service{}, &kapi.Service{Spec: kapi.ServiceSpec{ClusterIP: "10.0.0.1"}}))
+}
+
+func TestIsEngressHaveWeightedService(t *testing.T) {
+	ing := &aci.Ingress{
+		ObjectMeta: kapi.ObjectMeta{Name: "foo", Namespace: "default"},
+		Spec: aci.ExtendedIngressSpec{
+			Rules: []aci.ExtendedIngressRule{
+				{
+					ExtendedIngressRuleValue: aci.ExtendedIngressRuleValue{
+						HTTP: &aci.HTTPExtendedIngressRuleValue{
+							Paths: []aci.HTTPExtendedIngressPath{
+								{
+									Backends: []aci.WeightedBackend{
+										{ServiceName: "web-stable", Weight: 90},
+										{ServiceName: "web-canary", Weight: 10},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	ok, name, namespace := isEngressHaveService(ing, "web-canary.default")
+	assert.True(t, ok)
+	assert.Equal(t, "web-canary", name)
+	assert.Equal(t, "default", namespace)
+
+	ok, _, _ = isEngressHaveService(ing, "web.default")
+	assert.False(t, ok)
+}
@@ -0,0 +1,117 @@
+package ingress
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// HAProxy version of the load balancer image tag, ie. 1.7 of
+// appscode/haproxy:1.7.5-1.5.5
+var haproxyImageVersion = regexp.MustCompile(`:([0-9]+)\.([0-9]+)[^/:]*$`)
+
+type haproxyVersion struct {
+	major, minor int
+}
+
+func (v haproxyVersion) atLeast(o haproxyVersion) bool {
+	return v.major > o.major || (v.major == o.major && v.minor >= o.minor)
+}
+
+// imageHAProxyVersion returns the HAProxy version of a load balancer image.
+// ok is false if the version can not be detected from the image tag.
+func imageHAProxyVersion(image string) (v haproxyVersion, ok bool) {
+	m := haproxyImageVersion.FindStringSubmatch(image)
+	if m == nil {
+		return v, false
+	}
+	v.major, _ = strconv.Atoi(m[1])
+	v.minor, _ = strconv.Atoi(m[2])
+	return v, true
+}
+
+type balanceAlgorithm struct {
+	// minimum HAProxy version supporting the algorithm
+	since haproxyVersion
+	// only usable in http mode
+	httpOnly bool
+	// takes a parameter, ie. url_param <param>
+	param bool
+	// hash-type is applicable
+	hashing bool
+}
+
+var balanceAlgorithms = map[string]balanceAlgorithm{
+	"roundrobin": {since: haproxyVersion{1, 3}},
+	"leastconn":  {since: haproxyVersion{1, 3}},
+	"source":     {since: haproxyVersion{1, 3}, hashing: true},
+	"uri":        {since: haproxyVersion{1, 3}, hashing: true, httpOnly: true},
+	"url_param":  {since: haproxyVersion{1, 3}, hashing: true, httpOnly: true, param: true},
+	"hdr":        {since: haproxyVersion{1, 3}, hashing: true, httpOnly: true, param: true},
+	"random":     {since: haproxyVersion{1, 9}, hashing: true},
+}
+
+// balanceAlgorithmName returns the name of the algorithm of a balance
+// setting and whether its parameter is well-formed.
+func balanceAlgorithmName(balance string) (string, bool) {
+	if strings.HasPrefix(balance, "hdr(") {
+		name := strings.TrimSuffix(strings.TrimPrefix(balance, "hdr("), ")")
+		return "hdr", strings.HasSuffix(balance, ")") && name != "" && !strings.ContainsAny(name, " ()")
+	}
+	fields := strings.Fields(balance)
+	if len(fields) == 0 {
+		return "", false
+	}
+	algo, ok := balanceAlgorithms[fields[0]]
+	if !ok {
+		return fields[0], false
+	}
+	if algo.param {
+		return fields[0], len(fields) == 2
+	}
+	return fields[0], len(fields) == 1
+}
+
+type tlsPreset struct {
+	minVersion string
+	ciphers    string
+}
+
+// defaultDHParamSize is used by all presets, smaller DH params have to be
+// set explicitly.
+const defaultDHParamSize = 2048
+
+var tlsPresets = map[string]tlsPreset{
+	"modern": {
+		minVersion: "TLSv1.2",
+		ciphers:    "ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305:ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-SHA384:ECDHE-RSA-AES256-SHA384:ECDHE-ECDSA-AES128-SHA256:ECDHE-RSA-AES128-SHA256",
+	},
+	"intermediate": {
+		minVersion: "TLSv1.1",
+		ciphers:    "ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-AES256-GCM-SHA384:DHE-RSA-AES128-GCM-SHA256:DHE-DSS-AES128-GCM-SHA256:kEDH+AESGCM:ECDHE-RSA-AES128-SHA256:ECDHE-ECDSA-AES128-SHA256:ECDHE-RSA-AES128-SHA:ECDHE-ECDSA-AES128-SHA:ECDHE-RSA-AES256-SHA384:ECDHE-ECDSA-AES256-SHA384:ECDHE-RSA-AES256-SHA:ECDHE-ECDSA-AES256-SHA:DHE-RSA-AES128-SHA256:DHE-RSA-AES128-SHA:DHE-DSS-AES128-SHA256:DHE-RSA-AES256-SHA256:DHE-DSS-AES256-SHA:DHE-RSA-AES256-SHA:!aNULL:!eNULL:!EXPORT:!DES:!RC4:!3DES:!MD5:!PSK",
+	},
+	"old": {
+		minVersion: "TLSv1.0",
+		ciphers:    "ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-AES256-GCM-SHA384:DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-AES128-SHA256:ECDHE-RSA-AES128-SHA256:ECDHE-ECDSA-AES128-SHA:ECDHE-RSA-AES128-SHA:ECDHE-ECDSA-AES256-SHA384:ECDHE-RSA-AES256-SHA384:ECDHE-ECDSA-AES256-SHA:ECDHE-RSA-AES256-SHA:DHE-RSA-AES128-SHA256:DHE-RSA-AES128-SHA:DHE-RSA-AES256-SHA256:DHE-RSA-AES256-SHA:AES128-GCM-SHA256:AES256-GCM-SHA384:AES128-SHA256:AES256-SHA256:AES128-SHA:AES256-SHA:DES-CBC3-SHA:!aNULL:!eNULL:!EXPORT:!RC4:!MD5:!PSK",
+	},
+}
+
+// TLS protocol versions in ascending order, with the bind option disabling
+// each and the minimum HAProxy version of the option
+var tlsVersions = []struct {
+	name    string
+	disable string
+	since   haproxyVersion
+}{
+	{"SSLv3", "no-sslv3", haproxyVersion{1, 5}},
+	{"TLSv1.0", "no-tlsv10", haproxyVersion{1, 5}},
+	{"TLSv1.1", "no-tlsv11", haproxyVersion{1, 5}},
+	{"TLSv1.2", "no-tlsv12", haproxyVersion{1, 5}},
+	{"TLSv1.3", "no-tlsv13", haproxyVersion{1, 8}},
+}
+
+// minimum HAProxy versions of HTTP/2 toward clients and toward servers
+var (
+	http2FrontendSince = haproxyVersion{1, 8}
+	http2BackendSince  = haproxyVersion{2, 0}
+)
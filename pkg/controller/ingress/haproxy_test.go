@@ -0,0 +1,51 @@
+package ingress
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageHAProxyVersion(t *testing.T) {
+	dataTable := map[string]struct {
+		version haproxyVersion
+		ok      bool
+	}{
+		"appscode/haproxy:1.7.5-1.5.5":             {haproxyVersion{1, 7}, true},
+		"registry.local:5000/haproxy:1.9.2-alpine": {haproxyVersion{1, 9}, true},
+		"registry.local:5000/haproxy":              {haproxyVersion{}, false},
+		"appscode/haproxy:latest":                  {haproxyVersion{}, false},
+		"":                                         {haproxyVersion{}, false},
+	}
+	for image, expected := range dataTable {
+		v, ok := imageHAProxyVersion(image)
+		assert.Equal(t, expected.ok, ok, image)
+		assert.Equal(t, expected.version, v, image)
+	}
+
+	assert.True(t, haproxyVersion{1, 9}.atLeast(haproxyVersion{1, 9}))
+	assert.True(t, haproxyVersion{2, 0}.atLeast(haproxyVersion{1, 9}))
+	assert.False(t, haproxyVersion{1, 7}.atLeast(haproxyVersion{1, 9}))
+}
+
+func TestBalanceAlgorithmName(t *testing.T) {
+	dataTable := map[string]struct {
+		name string
+		ok   bool
+	}{
+		"leastconn":        {"leastconn", true},
+		"url_param userid": {"url_param", true},
+		"url_param":        {"url_param", false},
+		"uri whole":        {"uri", false},
+		"hdr(X-Tenant)":    {"hdr", true},
+		"hdr()":            {"hdr", false},
+		"hdr(X-Tenant":     {"hdr", false},
+		"weighted-fair":    {"weighted-fair", false},
+		"":                 {"", false},
+	}
+	for balance, expected := range dataTable {
+		name, ok := balanceAlgorithmName(balance)
+		assert.Equal(t, expected.name, name, balance)
+		assert.Equal(t, expected.ok, ok, balance)
+	}
+}
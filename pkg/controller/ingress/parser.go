@@ -2,13 +2,17 @@ package ingress
 
 import (
 	"encoding/json"
+	"net"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/appscode/errors"
 	"github.com/appscode/go/arrays"
-	"github.com/appscode/go/crypto/rand"
 	stringutil "github.com/appscode/go/strings"
+	aci "github.com/appscode/k8s-addons/api"
 	"github.com/appscode/log"
 	"github.com/appscode/voyager/pkg/controller/ingress/template"
 	"github.com/flosch/pongo2"
@@ -45,6 +49,9 @@ func (lbc *EngressController) serviceEndpoints(name string, port intstr.IntOrStr
 	if err != nil {
 		return nil, errors.FromErr(err).Err()
 	}
+	if service.Spec.Type == kapi.ServiceTypeExternalName {
+		return lbc.externalNameEndpoints(service, port)
+	}
 	p, ok := getSpecifiedPort(service.Spec.Ports, port)
 	if !ok {
 		return nil, errors.New("service port unavaiable").Err()
@@ -89,7 +96,7 @@ func (lbc *EngressController) getEndpoints(s *kapi.Service, servicePort *kapi.Se
 			for _, epAddress := range ss.Addresses {
 				if isForwardable(hostNames, epAddress.Hostname) {
 					ep := &Endpoint{
-						Name: "server-" + epAddress.IP,
+						Name: "server-" + endpointName(epAddress),
 						IP:   epAddress.IP,
 						Port: targetPort,
 					}
@@ -110,9 +117,214 @@ func (lbc *EngressController) getEndpoints(s *kapi.Service, servicePort *kapi.Se
 			}
 		}
 	}
+	// the order of endpoint addresses is not guaranteed
+	sort.Sort(endpointsByName(eps))
+	uniqueEndpointNames(eps)
 	return
 }
 
+// endpointName returns the name of the pod of an endpoint address, so its
+// server keeps the name while the pod lives. Addresses not backed by a pod
+// are named by their IP.
+func endpointName(addr kapi.EndpointAddress) string {
+	if addr.TargetRef != nil && addr.TargetRef.Kind == "Pod" && addr.TargetRef.Name != "" {
+		return addr.TargetRef.Name
+	}
+	return addr.IP
+}
+
+// endpointsByName orders endpoints by name, then by address.
+type endpointsByName []*Endpoint
+
+func (e endpointsByName) Len() int      { return len(e) }
+func (e endpointsByName) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+
+func (e endpointsByName) Less(i, j int) bool {
+	if e[i].Name != e[j].Name {
+		return e[i].Name < e[j].Name
+	}
+	if e[i].IP != e[j].IP {
+		return e[i].IP < e[j].IP
+	}
+	return e[i].Port < e[j].Port
+}
+
+// uniqueEndpointNames suffixes the names of endpoints repeated in a backend
+// with their port, ie. pods serving several matching ports. Endpoints listed
+// twice are told apart by their position.
+func uniqueEndpointNames(eps []*Endpoint) {
+	count := make(map[string]int)
+	for _, ep := range eps {
+		count[ep.Name]++
+	}
+	for _, ep := range eps {
+		if count[ep.Name] > 1 {
+			ep.Name += ":" + ep.Port
+		}
+	}
+	count = make(map[string]int)
+	for _, ep := range eps {
+		count[ep.Name]++
+	}
+	for i, ep := range eps {
+		if count[ep.Name] > 1 {
+			ep.Name += "-" + strconv.Itoa(i)
+		}
+	}
+}
+
+// externalNameEndpoints returns the external name of an ExternalName service
+// as the only endpoint. It has no Endpoints object, so the port is the target
+// port of the specified service port or the specified port itself.
+func (lbc *EngressController) externalNameEndpoints(s *kapi.Service, port intstr.IntOrString) ([]*Endpoint, error) {
+	target := port.IntValue()
+	if p, ok := getSpecifiedPort(s.Spec.Ports, port); ok && getTargetPort(p) > 0 {
+		target = getTargetPort(p)
+	}
+	if target <= 0 {
+		return nil, errors.New("service port unavaiable").Err()
+	}
+	ep := &Endpoint{
+		Name: "server-" + s.Spec.ExternalName,
+		IP:   s.Spec.ExternalName,
+		Port: strconv.Itoa(target),
+	}
+	lbc.resolveEndpoint(ep)
+	return []*Endpoint{ep}, nil
+}
+
+// backendEndpoints returns the static endpoints of a backend if it lists
+// any, otherwise the endpoints of its service.
+func (lbc *EngressController) backendEndpoints(static []string, name string, port intstr.IntOrString, hostNames []string) ([]*Endpoint, error) {
+	if len(static) == 0 {
+		return lbc.serviceEndpoints(name, port, hostNames)
+	}
+	eps := make([]*Endpoint, 0)
+	for _, addr := range static {
+		host, p, err := net.SplitHostPort(strings.TrimSpace(addr))
+		if err != nil {
+			log.Errorln("Skipping endpoint", addr, "cause", err)
+			continue
+		}
+		if n, err := strconv.Atoi(p); err != nil || n <= 0 || n > 65535 {
+			log.Errorln("Skipping endpoint", addr, "cause port", p, "is invalid")
+			continue
+		}
+		ep := &Endpoint{
+			Name: "server-" + host + ":" + p,
+			IP:   host,
+			Port: p,
+		}
+		lbc.resolveEndpoint(ep)
+		eps = append(eps, ep)
+	}
+	uniqueEndpointNames(eps)
+	return eps, nil
+}
+
+// pathEndpoints returns the endpoints of the backend of a path, merged from
+// all services of a path splitting its traffic.
+func (lbc *EngressController) pathEndpoints(path aci.HTTPExtendedIngressPath) ([]*Endpoint, error) {
+	if len(path.Backends) == 0 {
+		return lbc.backendEndpoints(path.Backend.Endpoints, path.Backend.ServiceName, path.Backend.ServicePort, path.Backend.HostNames)
+	}
+	if path.Backend.ServiceName != "" || len(path.Backend.Endpoints) > 0 {
+		log.Warningln("Ignoring service of path", path.Path, "cause it splits traffic across backends")
+	}
+	groups := make([][]*Endpoint, 0)
+	weights := make([]int, 0)
+	for _, b := range path.Backends {
+		if b.Weight <= 0 {
+			log.Infoln("Service", b.ServiceName, "of path", path.Path, "has no weight, skipping")
+			continue
+		}
+		eps, err := lbc.serviceEndpoints(b.ServiceName, b.ServicePort, nil)
+		if err != nil {
+			log.Errorln("Skipping service", b.ServiceName, "of path", path.Path, "cause", err)
+			continue
+		}
+		// services may share pods, so servers are named by their service
+		for _, ep := range eps {
+			ep.Name = b.ServiceName + "-" + ep.Name
+		}
+		groups = append(groups, eps)
+		weights = append(weights, b.Weight)
+	}
+	eps := splitWeights(groups, weights)
+	uniqueEndpointNames(eps)
+	return eps, nil
+}
+
+// splitWeights merges the endpoints of several services and sets their
+// server weights, so each service gets its share of the requests split
+// among its endpoints by their own weight. The largest server weight is
+// HAProxy's maximum of 256.
+func splitWeights(groups [][]*Endpoint, weights []int) []*Endpoint {
+	shares := make(map[*Endpoint]float64)
+	var max float64
+	for i, eps := range groups {
+		var total int
+		for _, ep := range eps {
+			total += endpointWeight(ep)
+		}
+		for _, ep := range eps {
+			shares[ep] = float64(weights[i]) * float64(endpointWeight(ep)) / float64(total)
+			if shares[ep] > max {
+				max = shares[ep]
+			}
+		}
+	}
+
+	merged := make([]*Endpoint, 0)
+	for _, eps := range groups {
+		for _, ep := range eps {
+			ep.Weight = int(256*shares[ep]/max + 0.5)
+			if ep.Weight == 0 {
+				ep.Weight = 1
+			}
+			merged = append(merged, ep)
+		}
+	}
+	return merged
+}
+
+// endpointWeight returns the weight of a pod set by annotation, HAProxy's
+// default of 1 otherwise.
+func endpointWeight(ep *Endpoint) int {
+	if ep.Weight > 0 {
+		return ep.Weight
+	}
+	return 1
+}
+
+// resolveEndpoint marks an endpoint with a host name to be resolved through
+// the cluster DNS at runtime. Without nameservers HAProxy resolves the name
+// once on start.
+func (lbc *EngressController) resolveEndpoint(ep *Endpoint) {
+	if net.ParseIP(ep.IP) != nil {
+		return
+	}
+	if lbc.Parsed.Nameservers == nil {
+		lbc.Parsed.Nameservers = lbc.clusterNameservers()
+	}
+	ep.Resolve = len(lbc.Parsed.Nameservers) > 0
+}
+
+// clusterNameservers returns the address of the cluster DNS service.
+func (lbc *EngressController) clusterNameservers() []string {
+	nameservers := make([]string, 0)
+	svc, err := lbc.KubeClient.Core().Services(clusterDNSNamespace).Get(clusterDNSName)
+	if err != nil {
+		log.Warningln("Cluster DNS not found, resolving host names on start only,", err)
+		return nameservers
+	}
+	if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == kapi.ClusterIPNone {
+		log.Warningln("Cluster DNS has no cluster ip, resolving host names on start only")
+		return nameservers
+	}
+	return append(nameservers, net.JoinHostPort(svc.Spec.ClusterIP, "53"))
+}
+
 func isForwardable(hostNames []string, hostName string) bool {
 	if len(hostNames) <= 0 {
 		return true
@@ -181,9 +393,10 @@ func getTargetPort(servicePort *kapi.ServicePort) int {
 func (lbc *EngressController) parseSpec() {
 	log.Infoln("Parsing Engress specs")
 	lbc.Options.Ports = make([]int, 0)
+	lbc.Parsed.Nameservers = nil
 	if lbc.Config.Spec.Backend != nil {
 		log.Debugln("generating default backend", lbc.Config.Spec.Backend.RewriteRule, lbc.Config.Spec.Backend.HeaderRule)
-		eps, _ := lbc.serviceEndpoints(lbc.Config.Spec.Backend.ServiceName, lbc.Config.Spec.Backend.ServicePort, lbc.Config.Spec.Backend.HostNames)
+		eps, _ := lbc.backendEndpoints(lbc.Config.Spec.Backend.Endpoints, lbc.Config.Spec.Backend.ServiceName, lbc.Config.Spec.Backend.ServicePort, lbc.Config.Spec.Backend.HostNames)
 		lbc.Parsed.DefaultBackend = &Backend{
 			Name:      "default-backend",
 			Endpoints: eps,
@@ -191,45 +404,123 @@ func (lbc *EngressController) parseSpec() {
 			BackendRules: lbc.Config.Spec.Backend.BackendRule,
 			RewriteRules: lbc.Config.Spec.Backend.RewriteRule,
 			HeaderRules:  lbc.Config.Spec.Backend.HeaderRule,
+			HealthCheck:  parseHealthCheck(lbc.Config.Spec.Backend.HealthCheck),
+			RateLimit:    parseRateLimit(lbc.Config.Spec.Backend.RateLimit),
+			ErrorFiles:   lbc.parseErrorFiles(lbc.Config.Spec.Backend.ErrorFiles),
+			UpstreamTLS:  lbc.parseUpstreamTLS(lbc.Config.Spec.Backend.UpstreamTLS),
+			H2:           parseBackendH2(lbc.Config.Spec.Backend.Protocol, GetLoadbalancerImage()),
+			SendProxy:    parseSendProxy(lbc.Config.Spec.Backend.SendProxy),
 		}
+		lbc.Parsed.DefaultBackend.Balance, lbc.Parsed.DefaultBackend.HashType = parseBalance(lbc.Config.Spec.Backend.Balance, lbc.Config.Spec.Backend.HashType, GetLoadbalancerImage(), true)
+		lbc.Parsed.DefaultBackend.SessionAffinity = parseSessionAffinity(lbc.Config.Spec.Backend.SessionAffinity, lbc.Parsed.Sticky, true)
 	}
+	lbc.Parsed.SSLRedirectHosts = make([]string, 0)
+	passthroughHosts := make([]string, 0)
+	lbc.Parsed.ClientAuth = make([]*ClientAuth, 0)
+	lbc.Parsed.HttpsTLSProfiles = make([]*TLSProfile, 0)
+	lbc.Parsed.HSTS = make([]*HSTS, 0)
 	if len(lbc.Config.Spec.TLS) > 0 {
 		lbc.Options.SecretNames = make([]string, 0)
 		lbc.HostFilter = make([]string, 0)
 		for _, secret := range lbc.Config.Spec.TLS {
-			lbc.Options.SecretNames = append(lbc.Options.SecretNames, secret.SecretName)
-			lbc.HostFilter = append(lbc.HostFilter, secret.Hosts...)
+			if secret.Passthrough {
+				passthroughHosts = append(passthroughHosts, secret.Hosts...)
+				if secret.ClientAuth != nil || secret.HSTS != nil {
+					log.Warningln("Ignoring client auth and hsts of passthrough hosts", secret.Hosts)
+				}
+			} else {
+				lbc.Options.SecretNames = append(lbc.Options.SecretNames, secret.SecretName)
+				lbc.HostFilter = append(lbc.HostFilter, secret.Hosts...)
+				if auth := lbc.parseClientAuth(secret.ClientAuth, secret.Hosts); auth != nil {
+					lbc.Parsed.ClientAuth = append(lbc.Parsed.ClientAuth, auth)
+				}
+				if p := parseTLSProfile(secret, len(lbc.Parsed.HttpsTLSProfiles)+1); p != nil {
+					lbc.Parsed.HttpsTLSProfiles = append(lbc.Parsed.HttpsTLSProfiles, p)
+				}
+				if secret.HSTS != nil {
+					if len(secret.Hosts) > 0 {
+						lbc.Parsed.HSTS = append(lbc.Parsed.HSTS, &HSTS{Hosts: secret.Hosts, Value: parseHSTS(secret.HSTS)})
+					} else {
+						log.Warningln("Ignoring hsts of TLS secret", secret.SecretName, "without hosts")
+					}
+				}
+			}
+			if annotation(lbc.Config.Annotations).SSLRedirect() && !secret.DisableSSLRedirect {
+				lbc.Parsed.SSLRedirectHosts = append(lbc.Parsed.SSLRedirectHosts, secret.Hosts...)
+			}
 		}
 	}
 
+	lbc.Parsed.UserLists = make([]*UserList, 0)
 	lbc.Parsed.HttpService = make([]*Service, 0)
 	lbc.Parsed.HttpsService = make([]*Service, 0)
 	lbc.Parsed.TCPService = make([]*TCPService, 0)
 
-	var httpCount, httpsCount int
-	for _, rule := range lbc.Config.Spec.Rules {
+	// the ingress wide source range applies to tcp services too
+	var ingressRange *SourceRange
+	if len(lbc.Parsed.SourceRanges) > 0 {
+		ingressRange = lbc.Parsed.SourceRanges[0]
+	}
+
+	var httpCount int
+	httpPorts := lbc.httpRulePorts(passthroughHosts)
+	for i, rule := range lbc.Config.Spec.Rules {
 		host := rule.Host
-		if rule.HTTP != nil {
-			if ok, _ := arrays.Contains(lbc.HostFilter, host); ok {
-				httpsCount++
-			} else {
-				httpCount++
+		if ok, _ := arrays.Contains(passthroughHosts, host); ok && rule.HTTP != nil {
+			if def := lbc.parsePassthroughRule(rule, ingressRange); def != nil {
+				def.index = strconv.Itoa(i)
+				lbc.Parsed.TCPService = append(lbc.Parsed.TCPService, def)
+			}
+		} else if rule.HTTP != nil && httpPorts[i] > 0 {
+			if r := parseSourceRange(host, rule.WhitelistSourceRange, rule.BlacklistSourceRange); r != nil {
+				lbc.Parsed.SourceRanges = append(lbc.Parsed.SourceRanges, r)
+			}
+			httpCount++
+			if !containsPort(lbc.Options.Ports, httpPorts[i]) {
+				lbc.Options.Ports = append(lbc.Options.Ports, httpPorts[i])
 			}
 
-			for _, svc := range rule.HTTP.Paths {
+			for j, svc := range rulePaths(rule) {
+				pathMatch, err := parsePathMatch(svc.Path, svc.PathMatch)
+				if err != nil {
+					log.Errorln("Skipping path", svc.Path, "of host", host, "cause", err)
+					continue
+				}
+				matches, err := parseRequestMatches(svc)
+				if err != nil {
+					log.Errorln("Skipping path", svc.Path, "of host", host, "cause", err)
+					continue
+				}
 				def := &Service{
-					Name:     "service-" + rand.Characters(6),
-					Host:     host,
-					AclMatch: svc.Path,
+					Name:      serviceName(host, strconv.Itoa(httpPorts[i]), svc.Path, pathServiceName(svc)),
+					Host:      host,
+					Port:      strconv.Itoa(httpPorts[i]),
+					AclMatch:  svc.Path,
+					PathMatch: pathMatch,
+					Matches:   matches,
+					index:     strconv.Itoa(i) + "." + strconv.Itoa(j),
 				}
 
-				eps, err := lbc.serviceEndpoints(svc.Backend.ServiceName, svc.Backend.ServicePort, svc.Backend.HostNames)
+				eps, err := lbc.pathEndpoints(svc)
 				def.Backends = &Backend{
-					Name:         "backend-" + rand.Characters(5),
+					Name:         "backend-" + pathServiceName(svc),
 					Endpoints:    eps,
 					BackendRules: svc.Backend.BackendRule,
 					RewriteRules: svc.Backend.RewriteRule,
 					HeaderRules:  svc.Backend.HeaderRule,
+					HealthCheck:  parseHealthCheck(svc.Backend.HealthCheck),
+					RateLimit:    parseRateLimit(svc.Backend.RateLimit),
+					ErrorFiles:   lbc.parseErrorFiles(svc.Backend.ErrorFiles),
+					UpstreamTLS:  lbc.parseUpstreamTLS(svc.Backend.UpstreamTLS),
+					H2:           parseBackendH2(svc.Backend.Protocol, GetLoadbalancerImage()),
+					SendProxy:    parseSendProxy(svc.Backend.SendProxy),
+				}
+				def.Backends.Balance, def.Backends.HashType = parseBalance(svc.Backend.Balance, svc.Backend.HashType, GetLoadbalancerImage(), true)
+				def.Backends.SessionAffinity = parseSessionAffinity(svc.Backend.SessionAffinity, lbc.Parsed.Sticky, true)
+				if svc.BasicAuth != nil {
+					def.Backends.BasicAuth = lbc.parseBasicAuth(svc.BasicAuth)
+				} else {
+					def.Backends.BasicAuth = lbc.parseBasicAuth(rule.BasicAuth)
 				}
 
 				log.Debugln("Got endpoints", len(eps))
@@ -245,22 +536,46 @@ func (lbc *EngressController) parseSpec() {
 		}
 
 		// adding tcp service to the parser.
-		for _, tcpSvc := range rule.TCP {
-			lbc.Options.Ports = append(lbc.Options.Ports, tcpSvc.Port.IntValue())
+		for j, tcpSvc := range rule.TCP {
+			if !containsPort(lbc.Options.Ports, tcpSvc.Port.IntValue()) {
+				lbc.Options.Ports = append(lbc.Options.Ports, tcpSvc.Port.IntValue())
+			}
 			def := &TCPService{
-				Name:        "service-" + rand.Characters(6),
+				Name:        serviceName(host, tcpSvc.Port.String(), "", backendServiceName(tcpSvc.Backend.ServiceName, tcpSvc.Backend.ServicePort, tcpSvc.Backend.Endpoints)),
 				Host:        host,
 				Port:        tcpSvc.Port.String(),
 				SecretName:  tcpSvc.SecretName,
 				ALPNOptions: parseALPNOptions(tcpSvc.ALPN),
+				index:       strconv.Itoa(i) + "." + strconv.Itoa(j),
+			}
+			if tcpSvc.SecretName != "" {
+				def.ClientAuth = lbc.parseClientAuth(tcpSvc.ClientAuth, nil)
+			} else if tcpSvc.ClientAuth != nil {
+				log.Warningln("Ignoring client auth of tcp port", tcpSvc.Port.String(), "without secretName")
+			}
+			def.SourceRanges = make([]*SourceRange, 0)
+			for _, r := range []*SourceRange{
+				ingressRange,
+				parseSourceRange("", rule.WhitelistSourceRange, rule.BlacklistSourceRange),
+				parseSourceRange("", tcpSvc.WhitelistSourceRange, tcpSvc.BlacklistSourceRange),
+			} {
+				if r != nil {
+					def.SourceRanges = append(def.SourceRanges, r)
+				}
 			}
 			log.Infoln(tcpSvc.Backend.ServiceName, tcpSvc.Backend.ServicePort)
-			eps, err := lbc.serviceEndpoints(tcpSvc.Backend.ServiceName, tcpSvc.Backend.ServicePort, tcpSvc.Backend.HostNames)
+			eps, err := lbc.backendEndpoints(tcpSvc.Backend.Endpoints, tcpSvc.Backend.ServiceName, tcpSvc.Backend.ServicePort, tcpSvc.Backend.HostNames)
 			def.Backends = &Backend{
-				Name:         "backend-" + rand.Characters(5),
+				Name:         "backend-" + backendServiceName(tcpSvc.Backend.ServiceName, tcpSvc.Backend.ServicePort, tcpSvc.Backend.Endpoints),
 				BackendRules: tcpSvc.Backend.BackendRule,
 				Endpoints:    eps,
+				HealthCheck:  parseHealthCheck(tcpSvc.Backend.HealthCheck),
+				RateLimit:    parseRateLimit(tcpSvc.Backend.RateLimit),
+				UpstreamTLS:  lbc.parseUpstreamTLS(tcpSvc.Backend.UpstreamTLS),
+				SendProxy:    parseSendProxy(tcpSvc.Backend.SendProxy),
 			}
+			def.Backends.Balance, def.Backends.HashType = parseBalance(tcpSvc.Backend.Balance, tcpSvc.Backend.HashType, GetLoadbalancerImage(), false)
+			def.Backends.SessionAffinity = parseSessionAffinity(tcpSvc.Backend.SessionAffinity, lbc.Parsed.Sticky, false)
 
 			log.Debugln("Got endpoints", len(eps))
 			if len(eps) > 0 && err == nil {
@@ -270,12 +585,54 @@ func (lbc *EngressController) parseSpec() {
 		}
 	}
 
-	if httpCount > 0 || (lbc.Config.Spec.Backend != nil && httpsCount == 0) {
-		lbc.Options.Ports = append(lbc.Options.Ports, 80)
+	lbc.Parsed.HSTS = append(lbc.Parsed.HSTS, &HSTS{Value: parseHSTS(annotation(lbc.Config.Annotations).HSTS())})
+
+	// HAProxy evaluates use_backend rules top-down, so the most specific
+	// routes have to come first regardless of their order in the spec.
+	sort.Stable(servicesByPriority(lbc.Parsed.HttpService))
+	sort.Stable(servicesByPriority(lbc.Parsed.HttpsService))
+	uniqueServiceNames(lbc.Parsed.HttpService, lbc.Parsed.HttpsService, lbc.Parsed.TCPService)
+	for _, svc := range append(unreachableServices(lbc.Parsed.HttpService), unreachableServices(lbc.Parsed.HttpsService)...) {
+		log.Warningln("Ingress", lbc.Config.Name, lbc.Config.Namespace, "path", svc.AclMatch, "of host", svc.Host, "can never match")
+	}
+	lbc.Parsed.HttpFrontends = groupHTTPServices(lbc.Parsed.HttpService, "http-frontend", "80")
+	lbc.Parsed.HttpsFrontends = groupHTTPServices(lbc.Parsed.HttpsService, "https-frontend", "443")
+	if len(lbc.Parsed.SSLRedirectHosts) > 0 && httpFrontend(lbc.Parsed.HttpFrontends, "80") == nil {
+		// hosts are redirected from port 80
+		lbc.Parsed.HttpFrontends = append([]*HTTPFrontend{{
+			Name:     "http-frontend",
+			Port:     "80",
+			Services: make([]*Service, 0),
+		}}, lbc.Parsed.HttpFrontends...)
 	}
 
-	if httpsCount > 0 {
-		lbc.Options.Ports = append(lbc.Options.Ports, 443)
+	// TLS passthrough and profiles are routed on port 443 only
+	https := httpFrontend(lbc.Parsed.HttpsFrontends, "443") != nil
+	lbc.Parsed.TCPFrontends = groupTCPServices(lbc.Parsed.TCPService)
+	if https && len(lbc.Parsed.HttpsTLSProfiles) > 0 && !passthroughHTTPS(lbc.Parsed.TCPFrontends) {
+		// hosts with TLS profiles are routed by the TLS server name on 443
+		lbc.Parsed.TCPFrontends = append(lbc.Parsed.TCPFrontends, &TCPFrontend{
+			Port:        "443",
+			SecretNames: make([]string, 0),
+			Services:    make([]*TCPService, 0),
+		})
+	}
+	lbc.Parsed.HttpsPassthrough = https && passthroughHTTPS(lbc.Parsed.TCPFrontends)
+	if !lbc.Parsed.HttpsPassthrough {
+		for _, p := range lbc.Parsed.HttpsTLSProfiles {
+			log.Warningln("Ignoring TLS options of hosts", p.Hosts, "cause they only apply to https on port 443")
+		}
+		lbc.Parsed.HttpsTLSProfiles = make([]*TLSProfile, 0)
+	}
+	if hosts := ignoredTLSProfileHosts(lbc.Parsed.HttpsTLSProfiles, lbc.Parsed.HttpsFrontends); len(hosts) > 0 {
+		log.Warningln("Hosts", hosts, "use the ingress wide TLS options on ports other than 443")
+	}
+	setClientCA(clientCA(lbc.Parsed.ClientAuth, "https hosts"), lbc.Parsed.ClientAuth, lbc.Parsed.HttpsFrontends, lbc.Parsed.HttpsTLSProfiles)
+
+	if len(lbc.Parsed.SSLRedirectHosts) > 0 || ((lbc.Config.Spec.Backend != nil || lbc.Parsed.NoRoute != nil) && httpCount == 0) {
+		if !containsPort(lbc.Options.Ports, 80) {
+			lbc.Options.Ports = append(lbc.Options.Ports, 80)
+		}
 	}
 
 	//parse stat
@@ -292,10 +649,33 @@ func (lbc *EngressController) parseOptions() {
 	log.Infoln("Parsing annotations.")
 	opts := annotation(lbc.Config.ObjectMeta.Annotations)
 	lbc.Parsed.Sticky = opts.StickySession()
+	lbc.Parsed.MaxConn = opts.MaxConn()
+	lbc.Parsed.TimeoutConnect = opts.Timeout(TimeoutConnect)
+	lbc.Parsed.TimeoutClient = opts.Timeout(TimeoutClient)
+	lbc.Parsed.TimeoutClientFin = opts.Timeout(TimeoutClientFin)
+	lbc.Parsed.TimeoutServer = opts.Timeout(TimeoutServer)
+	lbc.Parsed.TimeoutTunnel = opts.Timeout(TimeoutTunnel)
+	lbc.Parsed.ConnectionMode = opts.ConnectionMode()
+	lbc.Parsed.HTTPReuse = opts.HTTPReuse()
+	lbc.Parsed.SSLRedirectCode = opts.SSLRedirectCode()
 	if len(lbc.Config.Spec.TLS) > 0 {
 		lbc.Parsed.SSLCert = true
 	}
 
+	lbc.Parsed.SourceRanges = make([]*SourceRange, 0)
+	if r := parseSourceRange("", opts.WhitelistSourceRange(), opts.BlacklistSourceRange()); r != nil {
+		lbc.Parsed.SourceRanges = append(lbc.Parsed.SourceRanges, r)
+	}
+	lbc.Parsed.TrustedProxies = parseCIDRs(opts.TrustedProxies())
+	lbc.Parsed.RateLimit = parseRateLimit(opts.RateLimit())
+	lbc.Parsed.TLS = parseTLSOptions(opts.TLSOptions(), GetLoadbalancerImage())
+	lbc.Parsed.SecureCookies = opts.SecureCookies()
+	lbc.Options.ErrorFilesConfigMaps = make([]string, 0)
+	lbc.Options.UpstreamTLSSecrets = make([]string, 0)
+	lbc.Options.ClientCASecrets = make([]string, 0)
+	lbc.Parsed.ErrorFiles = lbc.parseErrorFiles(opts.ErrorFiles())
+	lbc.Parsed.NoRoute = lbc.parseNoRoute(opts.NoRouteStatus(), opts.NoRouteErrorFiles())
+
 	lbc.Parsed.Stats = opts.Stats()
 	if lbc.Parsed.Stats {
 		secret, err := lbc.KubeClient.Core().Secrets(lbc.Config.ObjectMeta.Namespace).Get(opts.StatsSecretName())
@@ -312,6 +692,8 @@ func (lbc *EngressController) parseOptions() {
 	lbc.Options.DaemonNodeSelector = ParseNodeSelector(opts.DaemonNodeSelector())
 	lbc.Options.LoadBalancerIP = opts.LoadBalancerIP()
 	lbc.Options.LoadBalancerPersist = opts.LoadBalancerPersist()
+	lbc.Options.AcceptProxy = opts.AcceptProxy()
+	lbc.Parsed.AcceptProxy = lbc.Options.AcceptProxy
 	log.Infoln("Got LBType", lbc.Options.LBType)
 }
 
@@ -338,3 +720,1153 @@ func parseALPNOptions(opt []string) string {
 	}
 	return "alpn " + strings.Join(opt, ",")
 }
+
+var invalidNameChars = regexp.MustCompile(`[^A-Za-z0-9_.:-]+`)
+
+// serviceName derives the name of a service from its host, port, path and
+// the service it routes to, so the same spec always renders the same config.
+// Characters HAProxy does not allow in names are replaced.
+func serviceName(host, port, path, backend string) string {
+	if strings.HasPrefix(host, "*") {
+		host = "wildcard" + host[1:]
+	}
+	parts := make([]string, 0)
+	for _, part := range []string{host, port, path, backend} {
+		part = strings.Trim(invalidNameChars.ReplaceAllString(part, "_"), "_")
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// backendServiceName names the service and port of a backend, or its static
+// endpoints.
+func backendServiceName(name string, port intstr.IntOrString, static []string) string {
+	if len(static) > 0 {
+		return "endpoints"
+	}
+	return name + ":" + port.String()
+}
+
+// pathServiceName names the services the traffic of a path is sent to.
+func pathServiceName(path aci.HTTPExtendedIngressPath) string {
+	if len(path.Backends) == 0 {
+		return backendServiceName(path.Backend.ServiceName, path.Backend.ServicePort, path.Backend.Endpoints)
+	}
+	names := make([]string, 0)
+	for _, b := range path.Backends {
+		names = append(names, b.ServiceName)
+	}
+	return strings.Join(names, "_")
+}
+
+// uniqueServiceNames suffixes the names shared by several http, https or
+// tcp services, ie. paths of a host only differing by their request matches,
+// with the position of their rule and path in the spec. Service names never
+// end in digits separated by a dot, so suffixed names can not collide, and
+// services of unique names keep them whatever other rules are added.
+func uniqueServiceNames(http, https []*Service, tcp []*TCPService) {
+	for _, svcs := range [][]*Service{http, https} {
+		count := make(map[string]int)
+		for _, svc := range svcs {
+			count[svc.Name]++
+		}
+		for _, svc := range svcs {
+			if count[svc.Name] > 1 {
+				svc.Name += "-" + svc.index
+			}
+		}
+	}
+	count := make(map[string]int)
+	for _, svc := range tcp {
+		count[svc.Name]++
+	}
+	for _, svc := range tcp {
+		if count[svc.Name] > 1 {
+			svc.Name += "-" + svc.index
+		}
+	}
+}
+
+// parsePathMatch validates the path match mode of an http path and
+// returns the mode to use, falling back to prefix match if unset.
+func parsePathMatch(path, match string) (string, error) {
+	if strings.IndexFunc(path, unicode.IsSpace) >= 0 {
+		return "", errors.New("path contains whitespace", path).Err()
+	}
+	switch match {
+	case "", aci.PathMatchPrefix:
+		return aci.PathMatchPrefix, nil
+	case aci.PathMatchSegmentPrefix, aci.PathMatchExact:
+		return match, nil
+	case aci.PathMatchRegex:
+		if _, err := regexp.Compile(path); err != nil {
+			return "", errors.FromErr(err).Err()
+		}
+		return match, nil
+	}
+	return "", errors.New("unknown path match", match).Err()
+}
+
+var httpToken = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// parseRequestMatches returns the acl criteria of the header, cookie, query
+// parameter and method conditions of an http path. A request has to match all
+// of them to be routed to the path.
+func parseRequestMatches(path aci.HTTPExtendedIngressPath) ([]string, error) {
+	matches := make([]string, 0)
+	for _, m := range []struct {
+		fetch      string
+		conditions []aci.RequestMatch
+	}{
+		{"req.hdr", path.Headers},
+		{"req.cook", path.Cookies},
+		{"urlp", path.QueryParams},
+	} {
+		for _, c := range m.conditions {
+			if !httpToken.MatchString(c.Name) {
+				return nil, errors.New("invalid name", c.Name).Err()
+			}
+			criterion := m.fetch + "(" + c.Name + ")"
+			switch {
+			case c.Type != "" && c.Type != aci.RequestMatchExact && c.Type != aci.RequestMatchRegex:
+				return nil, errors.New("unknown match type", c.Type).Err()
+			case c.Value == "":
+				criterion += " -m found"
+			case c.Type == aci.RequestMatchRegex:
+				if _, err := regexp.Compile(c.Value); err != nil {
+					return nil, errors.FromErr(err).Err()
+				}
+				criterion += " -m reg " + escapeACLValue(c.Value)
+			default:
+				criterion += " -m str " + escapeACLValue(c.Value)
+			}
+			matches = append(matches, criterion)
+		}
+	}
+	if len(path.Methods) > 0 {
+		methods := make([]string, 0, len(path.Methods))
+		for _, method := range path.Methods {
+			method = strings.ToUpper(method)
+			if !httpToken.MatchString(method) {
+				return nil, errors.New("invalid method", method).Err()
+			}
+			methods = append(methods, method)
+		}
+		matches = append(matches, "method "+strings.Join(methods, " "))
+	}
+	return matches, nil
+}
+
+// escapeACLValue escapes the characters HAProxy treats specially in the
+// arguments of a config line.
+func escapeACLValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, " ", `\ `, "#", `\#`, `"`, `\"`, "'", `\'`).Replace(v)
+}
+
+// servicesByPriority orders http services in the order HAProxy should try
+// them: exact hosts before wildcard hosts before no host, then longer paths
+// before shorter ones, then an exact path before other matches of the same
+// path, and at last paths with more request matches before paths with less.
+// The length of a regular expression says nothing about what it matches, so
+// regex paths keep their order in the spec after the other paths of a host,
+// followed by a prefix path of / and the catch all without a path, which
+// would otherwise match every request before the regex paths.
+type servicesByPriority []*Service
+
+func (s servicesByPriority) Len() int      { return len(s) }
+func (s servicesByPriority) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+func (s servicesByPriority) Less(i, j int) bool {
+	a, b := s[i], s[j]
+	if hostRank(a.Host) != hostRank(b.Host) {
+		return hostRank(a.Host) < hostRank(b.Host)
+	}
+	if len(a.Host) != len(b.Host) {
+		return len(a.Host) > len(b.Host)
+	}
+	if a.Host != b.Host {
+		return a.Host < b.Host
+	}
+	if pathGroup(a) != pathGroup(b) {
+		return pathGroup(a) < pathGroup(b)
+	}
+	if pathGroup(a) == 1 {
+		return false
+	}
+	if len(a.AclMatch) != len(b.AclMatch) {
+		return len(a.AclMatch) > len(b.AclMatch)
+	}
+	if pathMatchRank(a.PathMatch) != pathMatchRank(b.PathMatch) {
+		return pathMatchRank(a.PathMatch) < pathMatchRank(b.PathMatch)
+	}
+	return len(a.Matches) > len(b.Matches)
+}
+
+// pathGroup ranks services with a prefix or exact path before those with a
+// regex path, those before a prefix path of / and those before the catch all
+// without a path.
+func pathGroup(svc *Service) int {
+	if svc.AclMatch == "" {
+		return 3
+	}
+	if svc.PathMatch == aci.PathMatchRegex {
+		return 1
+	}
+	if svc.AclMatch == "/" && svc.PathMatch != aci.PathMatchExact {
+		return 2
+	}
+	return 0
+}
+
+func hostRank(host string) int {
+	if host == "" {
+		return 2
+	}
+	if strings.HasPrefix(host, "*") {
+		return 1
+	}
+	return 0
+}
+
+func pathMatchRank(match string) int {
+	switch match {
+	case aci.PathMatchExact:
+		return 0
+	case aci.PathMatchSegmentPrefix:
+		return 1
+	case aci.PathMatchPrefix:
+		return 2
+	}
+	return 3
+}
+
+// unreachableServices returns the services of a sorted list that can never
+// be selected, either because an earlier service has the same host, port,
+// path and request matches or because the path does not begin with a '/'.
+// Only these exact duplicates are found, not paths shadowed by an earlier
+// path matching a superset of requests, ie. a Prefix /api before a regex path.
+func unreachableServices(svcs []*Service) []*Service {
+	unreachable := make([]*Service, 0)
+	seen := make(map[string]bool)
+	for _, svc := range svcs {
+		key := svc.Port + " " + svc.Host + " " + svc.PathMatch + " " + svc.AclMatch + " " + strings.Join(svc.Matches, " ")
+		if seen[key] || (svc.AclMatch != "" && svc.PathMatch != aci.PathMatchRegex && !strings.HasPrefix(svc.AclMatch, "/")) {
+			unreachable = append(unreachable, svc)
+		}
+		seen[key] = true
+	}
+	return unreachable
+}
+
+// parseBasicAuth adds the user list of the referenced secret to the parsed
+// options and returns the basic auth settings of a backend. If the secret can
+// not be loaded the user list is left empty, so all requests are rejected.
+func (lbc *EngressController) parseBasicAuth(auth *aci.BasicAuth) *BasicAuth {
+	if auth == nil {
+		return nil
+	}
+	ba := &BasicAuth{
+		UserList: "auth-" + auth.SecretName,
+		Realm:    "Restricted",
+	}
+	if auth.Realm != "" {
+		ba.Realm = strings.Replace(strings.Replace(auth.Realm, `"`, "", -1), " ", `\ `, -1)
+	}
+
+	for _, list := range lbc.Parsed.UserLists {
+		if list.Name == ba.UserList {
+			return ba
+		}
+	}
+	list := &UserList{
+		Name:  ba.UserList,
+		Users: make([]*User, 0),
+	}
+	secret, err := lbc.KubeClient.Core().Secrets(lbc.Config.Namespace).Get(auth.SecretName)
+	if err == nil {
+		list.Users = parseHTPasswd(secret.Data)
+	} else {
+		log.Errorln("Error encountered while loading basic auth secret,", err)
+	}
+	lbc.Parsed.UserLists = append(lbc.Parsed.UserLists, list)
+	return ba
+}
+
+// parseHTPasswd reads the user:password lines stored in all keys of a secret.
+func parseHTPasswd(data map[string][]byte) []*User {
+	keys := make([]string, 0)
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	users := make([]*User, 0)
+	for _, k := range keys {
+		for _, line := range strings.Split(string(data[k]), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.ContainsAny(line, " \t") {
+				log.Warningln("Skipping invalid htpasswd entry in key", k)
+				continue
+			}
+			users = append(users, &User{Name: parts[0], Password: parts[1]})
+		}
+	}
+	return users
+}
+
+// rulePaths returns the paths of an http rule, followed by a catch all path
+// to the backend of the rule if it has one.
+func rulePaths(rule aci.ExtendedIngressRule) []aci.HTTPExtendedIngressPath {
+	paths := append([]aci.HTTPExtendedIngressPath{}, rule.HTTP.Paths...)
+	if rule.Backend != nil {
+		paths = append(paths, aci.HTTPExtendedIngressPath{Backend: *rule.Backend})
+	}
+	return paths
+}
+
+// parsePassthroughRule returns the tcp service on port 443 passing through
+// TLS of the host of a rule. Paths can not be inspected in TLS, so the
+// backend of the root path, or else of the first path is used.
+func (lbc *EngressController) parsePassthroughRule(rule aci.ExtendedIngressRule, ingressRange *SourceRange) *TCPService {
+	paths := rulePaths(rule)
+	if len(paths) == 0 {
+		return nil
+	}
+	path := paths[0]
+	for _, p := range paths {
+		if p.Path == "" || p.Path == "/" {
+			path = p
+			break
+		}
+	}
+	if len(paths) > 1 {
+		log.Warningln("Passthrough host", rule.Host, "routes all paths to", path.Backend.ServiceName)
+	}
+
+	if !containsPort(lbc.Options.Ports, 443) {
+		lbc.Options.Ports = append(lbc.Options.Ports, 443)
+	}
+	def := &TCPService{
+		Name:         serviceName(rule.Host, "443", "", pathServiceName(path)),
+		Host:         rule.Host,
+		Port:         "443",
+		SourceRanges: make([]*SourceRange, 0),
+	}
+	for _, r := range []*SourceRange{
+		ingressRange,
+		parseSourceRange("", rule.WhitelistSourceRange, rule.BlacklistSourceRange),
+	} {
+		if r != nil {
+			def.SourceRanges = append(def.SourceRanges, r)
+		}
+	}
+	eps, err := lbc.pathEndpoints(path)
+	def.Backends = &Backend{
+		Name:         "backend-" + pathServiceName(path),
+		BackendRules: path.Backend.BackendRule,
+		Endpoints:    eps,
+		HealthCheck:  parseHealthCheck(path.Backend.HealthCheck),
+		RateLimit:    parseRateLimit(path.Backend.RateLimit),
+	}
+	def.Backends.Balance, def.Backends.HashType = parseBalance(path.Backend.Balance, path.Backend.HashType, GetLoadbalancerImage(), false)
+	def.Backends.SessionAffinity = parseSessionAffinity(path.Backend.SessionAffinity, lbc.Parsed.Sticky, false)
+
+	log.Debugln("Got endpoints", len(eps))
+	if len(eps) == 0 || err != nil {
+		return nil
+	}
+	return def
+}
+
+// ignoredTLSProfileHosts returns the hosts with TLS options that are also
+// served on https ports other than 443. Those are not routed by the TLS
+// server name, so the ingress wide options apply there.
+func ignoredTLSProfileHosts(profiles []*TLSProfile, frontends []*HTTPFrontend) []string {
+	hosts := make([]string, 0)
+	for _, fe := range frontends {
+		if fe.Port == "443" {
+			continue
+		}
+		for _, svc := range fe.Services {
+			for _, p := range profiles {
+				if ok, _ := arrays.Contains(p.Hosts, svc.Host); ok && !stringutil.Contains(hosts, svc.Host) {
+					hosts = append(hosts, svc.Host)
+				}
+			}
+		}
+	}
+	return hosts
+}
+
+// passthroughHTTPS makes the tcp frontend on port 443, if any, forward the
+// connections of hosts not passed through to https-frontend.
+func passthroughHTTPS(frontends []*TCPFrontend) bool {
+	for _, fe := range frontends {
+		if fe.Port == "443" {
+			if fe.DefaultService != nil || len(fe.SecretNames) > 0 {
+				log.Errorln("Skipping tcp rules on port 443 cause they collide with the https hosts")
+				fe.SecretNames = make([]string, 0)
+				fe.DefaultService = nil
+				fe.ClientCA = nil
+			}
+			fe.HTTPS = true
+			fe.SNI = true
+			// other hosts are passed to the https-frontend
+			fe.SourceRanges = nil
+			return true
+		}
+	}
+	return false
+}
+
+// httpRulePorts returns the listen port of each http rule, 80 or 443 unless
+// set. A port can not be shared by http and https rules, nor with tcp rules
+// except for https on 443, which is reached through TLS passthrough then.
+// Rules on a colliding port get port 0 and are skipped.
+func (lbc *EngressController) httpRulePorts(passthroughHosts []string) []int {
+	tcpPorts := make([]int, 0)
+	for _, rule := range lbc.Config.Spec.Rules {
+		if ok, _ := arrays.Contains(passthroughHosts, rule.Host); ok && rule.HTTP != nil {
+			tcpPorts = append(tcpPorts, 443)
+		}
+		for _, tcpSvc := range rule.TCP {
+			tcpPorts = append(tcpPorts, tcpSvc.Port.IntValue())
+		}
+	}
+
+	ports := make([]int, len(lbc.Config.Spec.Rules))
+	tlsPorts := make(map[int]bool)
+	for i, rule := range lbc.Config.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		port := rule.Port.IntValue()
+		if ok, _ := arrays.Contains(passthroughHosts, rule.Host); ok {
+			if port != 0 && port != 443 {
+				log.Warningln("Passthrough host", rule.Host, "is served on port 443, ignoring port", rule.Port.String())
+			}
+			continue
+		}
+		tls, _ := arrays.Contains(lbc.HostFilter, rule.Host)
+		if port == 0 {
+			port = 80
+			if tls {
+				port = 443
+			}
+		}
+		if v, ok := tlsPorts[port]; ok && v != tls {
+			log.Errorln("Skipping rule of host", rule.Host, "cause port", port, "is shared by http and https hosts")
+			continue
+		}
+		if (containsPort(tcpPorts, port) && !(tls && port == 443)) || (lbc.Parsed.Stats && port == StatPort) {
+			log.Errorln("Skipping rule of host", rule.Host, "cause port", port, "is used by tcp rules or stats")
+			continue
+		}
+		tlsPorts[port] = tls
+		ports[i] = port
+	}
+	return ports
+}
+
+// groupHTTPServices merges the http services sharing a port into a single
+// frontend, keeping their order. The frontend on the default port is named
+// name, others are suffixed with their port.
+func groupHTTPServices(services []*Service, name, defaultPort string) []*HTTPFrontend {
+	frontends := make([]*HTTPFrontend, 0)
+	for _, svc := range services {
+		fe := httpFrontend(frontends, svc.Port)
+		if fe == nil {
+			fe = &HTTPFrontend{
+				Name:     name,
+				Port:     svc.Port,
+				Services: make([]*Service, 0),
+			}
+			if svc.Port != defaultPort {
+				fe.Name = name + "-" + svc.Port
+			}
+			frontends = append(frontends, fe)
+		}
+		fe.Services = append(fe.Services, svc)
+	}
+	return frontends
+}
+
+// httpFrontend returns the frontend listening on port, nil if there is none.
+func httpFrontend(frontends []*HTTPFrontend, port string) *HTTPFrontend {
+	for _, fe := range frontends {
+		if fe.Port == port {
+			return fe
+		}
+	}
+	return nil
+}
+
+// groupTCPServices merges the tcp services sharing a port into a single
+// frontend. A frontend terminates TLS if any of its services has a
+// certificate, otherwise TLS is passed through and services with a host are
+// routed by the server name of the TLS client hello.
+func groupTCPServices(services []*TCPService) []*TCPFrontend {
+	frontends := make([]*TCPFrontend, 0)
+	byPort := make(map[string]*TCPFrontend)
+	for _, svc := range services {
+		fe, ok := byPort[svc.Port]
+		if !ok {
+			fe = &TCPFrontend{
+				Port:        svc.Port,
+				SecretNames: make([]string, 0),
+				Services:    make([]*TCPService, 0),
+			}
+			byPort[svc.Port] = fe
+			frontends = append(frontends, fe)
+		}
+		fe.Services = append(fe.Services, svc)
+		if svc.SecretName != "" {
+			if ok, _ := arrays.Contains(fe.SecretNames, svc.SecretName); !ok {
+				fe.SecretNames = append(fe.SecretNames, svc.SecretName)
+			}
+		}
+	}
+
+	for _, fe := range frontends {
+		services := fe.Services
+		fe.Services = make([]*TCPService, 0)
+		hosts := make(map[string]bool)
+		auths := make([]*ClientAuth, 0)
+		for _, svc := range services {
+			if len(fe.SecretNames) > 0 && svc.SecretName == "" {
+				log.Errorln("Skipping tcp host", svc.Host, "on port", fe.Port, "cause TLS passthrough can not share a port with TLS termination")
+				continue
+			}
+			if hosts[svc.Host] {
+				log.Errorln("Skipping tcp host", svc.Host, "on port", fe.Port, "cause it is already used by another rule")
+				continue
+			}
+			hosts[svc.Host] = true
+			if svc.ALPNOptions != "" {
+				if fe.ALPNOptions == "" {
+					fe.ALPNOptions = svc.ALPNOptions
+				} else if fe.ALPNOptions != svc.ALPNOptions {
+					log.Warningln("Ignoring", svc.ALPNOptions, "of tcp host", svc.Host, "on port", fe.Port, "using", fe.ALPNOptions)
+				}
+			}
+			if svc.ClientAuth != nil {
+				auths = append(auths, svc.ClientAuth)
+			}
+			if svc.Host == "" {
+				fe.DefaultService = svc
+			} else {
+				fe.Services = append(fe.Services, svc)
+				fe.SNI = len(fe.SecretNames) == 0
+			}
+		}
+		fe.ClientCA = clientCA(auths, "tcp port "+fe.Port)
+		// connections to a port of a single service are checked on connect
+		if len(fe.Services) == 0 && fe.DefaultService != nil {
+			fe.SourceRanges = fe.DefaultService.SourceRanges
+		} else if len(fe.Services) == 1 && fe.DefaultService == nil {
+			fe.SourceRanges = fe.Services[0].SourceRanges
+		}
+		// exact hosts have to be matched before wildcard hosts
+		sort.Stable(tcpServicesByHost(fe.Services))
+	}
+	return frontends
+}
+
+// tcpServicesByHost orders tcp services with exact hosts before wildcard
+// hosts, and longer wildcard hosts first.
+type tcpServicesByHost []*TCPService
+
+func (s tcpServicesByHost) Len() int      { return len(s) }
+func (s tcpServicesByHost) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s tcpServicesByHost) Less(i, j int) bool {
+	if hostRank(s[i].Host) != hostRank(s[j].Host) {
+		return hostRank(s[i].Host) < hostRank(s[j].Host)
+	}
+	return len(s[i].Host) > len(s[j].Host)
+}
+
+func containsPort(ports []int, port int) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSourceRange returns the client ip restrictions of a host, nil if
+// there are none. A whitelist without any valid entry denies all clients.
+func parseSourceRange(host string, whitelist, blacklist []string) *SourceRange {
+	if len(whitelist) == 0 && len(blacklist) == 0 {
+		return nil
+	}
+	r := &SourceRange{
+		Host:      host,
+		Whitelist: parseCIDRs(whitelist),
+		Blacklist: parseCIDRs(blacklist),
+	}
+	if len(whitelist) > 0 && len(r.Whitelist) == 0 {
+		log.Errorln("No valid whitelist source range for host", host, "denying all clients")
+		// no client connects from 0.0.0.0
+		r.Whitelist = []string{"0.0.0.0/32"}
+	}
+	return r
+}
+
+// parseCIDRs returns the valid CIDRs and ip addresses of values.
+func parseCIDRs(values []string) []string {
+	cidrs := make([]string, 0)
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if _, _, err := net.ParseCIDR(v); err == nil || net.ParseIP(v) != nil {
+			cidrs = append(cidrs, v)
+		} else {
+			log.Warningln("Skipping invalid source range", v)
+		}
+	}
+	return cidrs
+}
+
+// parseErrorFiles returns the error pages stored in a ConfigMap and marks
+// the ConfigMap to be mounted in the HAProxy pods.
+func (lbc *EngressController) parseErrorFiles(name string) []*ErrorFile {
+	if name == "" {
+		return nil
+	}
+	cMap, err := lbc.KubeClient.Core().ConfigMaps(lbc.Config.Namespace).Get(name)
+	if err != nil {
+		log.Errorln("Error encountered while loading error files ConfigMap,", err)
+		return nil
+	}
+	files := make([]*ErrorFile, 0)
+	for _, code := range errorFileCodes {
+		if _, ok := cMap.Data[code+".http"]; ok {
+			files = append(files, &ErrorFile{
+				Code: code,
+				Path: errorFilesPath + name + "/" + code + ".http",
+			})
+		}
+	}
+	if len(files) == 0 {
+		log.Warningln("ConfigMap", name, "has no error files")
+		return nil
+	}
+	if ok, _ := arrays.Contains(lbc.Options.ErrorFilesConfigMaps, name); !ok {
+		lbc.Options.ErrorFilesConfigMaps = append(lbc.Options.ErrorFilesConfigMaps, name)
+	}
+	return files
+}
+
+// parseNoRoute returns the response to requests matching no route, nil if
+// the default backend of the ingress serves them or HAProxy should answer
+// with its stock 503 page.
+func (lbc *EngressController) parseNoRoute(status int, errorFiles string) *NoRoute {
+	if status == 0 && errorFiles == "" {
+		return nil
+	}
+	if lbc.Config.Spec.Backend != nil {
+		log.Warningln("Ignoring no route response cause the default backend serves unrouted requests")
+		return nil
+	}
+	r := &NoRoute{
+		Status:     503,
+		ErrorFiles: lbc.parseErrorFiles(errorFiles),
+	}
+	if status != 0 {
+		if ok, _ := arrays.Contains(errorFileCodes, strconv.Itoa(status)); ok {
+			r.Status = status
+		} else {
+			log.Warningln("Unsupported no route status", status, "using", r.Status)
+		}
+	}
+	return r
+}
+
+// parseClientAuth returns the client certificate authentication of hosts and
+// marks the CA Secret to be mounted in the HAProxy pods. If the CA is not
+// available, required authentication rejects all clients of the hosts.
+func (lbc *EngressController) parseClientAuth(ca *aci.ClientAuth, hosts []string) *ClientAuth {
+	if ca == nil {
+		return nil
+	}
+	auth := &ClientAuth{
+		Hosts:    hosts,
+		Required: true,
+	}
+	switch ca.Verify {
+	case "", aci.ClientAuthVerifyRequired:
+	case aci.ClientAuthVerifyOptional:
+		auth.Required = false
+	default:
+		log.Warningln("Invalid client auth verify", ca.Verify, "using", aci.ClientAuthVerifyRequired)
+	}
+
+	secret, err := lbc.KubeClient.Core().Secrets(lbc.Config.Namespace).Get(ca.CASecretName)
+	if err != nil {
+		log.Errorln("Error encountered while loading client CA secret,", err)
+	} else if _, ok := secret.Data["ca.crt"]; !ok {
+		log.Errorln("Client CA secret", ca.CASecretName, "has no ca.crt")
+	} else {
+		auth.CAFile = clientCASecretsPath + ca.CASecretName + "/ca.crt"
+		if _, ok := secret.Data["ca.crl"]; ok {
+			auth.CRLFile = clientCASecretsPath + ca.CASecretName + "/ca.crl"
+		}
+		if ok, _ := arrays.Contains(lbc.Options.ClientCASecrets, ca.CASecretName); !ok {
+			lbc.Options.ClientCASecrets = append(lbc.Options.ClientCASecrets, ca.CASecretName)
+		}
+		return auth
+	}
+	if auth.Required {
+		return auth
+	}
+	return nil
+}
+
+// clientCA returns the CA verifying the client certificates of a frontend. A
+// bind accepts a single CA, the first one is used for all hosts.
+func clientCA(auths []*ClientAuth, frontend string) *ClientAuth {
+	var ca *ClientAuth
+	for _, auth := range auths {
+		if auth.CAFile == "" {
+			continue
+		}
+		if ca == nil {
+			ca = auth
+		} else if ca.CAFile != auth.CAFile || ca.CRLFile != auth.CRLFile {
+			log.Errorln("Verifying client certificates of", auth.Hosts, "with", ca.CAFile, "cause", frontend, "share a single CA")
+		}
+	}
+	return ca
+}
+
+// setClientCA sets the CA on the binds serving hosts with client auth, so
+// clients of other hosts are not asked for a certificate. Hosts with a TLS
+// profile are bound through the profile on port 443 only.
+func setClientCA(ca *ClientAuth, auths []*ClientAuth, frontends []*HTTPFrontend, profiles []*TLSProfile) {
+	if ca == nil {
+		return
+	}
+	hasClientAuth := func(host string) bool {
+		for _, auth := range auths {
+			if auth.CAFile == "" {
+				continue
+			}
+			if ok, _ := arrays.Contains(auth.Hosts, host); ok || len(auth.Hosts) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	profileHosts := make([]string, 0)
+	for _, p := range profiles {
+		for _, host := range p.Hosts {
+			if hasClientAuth(host) {
+				p.ClientCA = ca
+			}
+		}
+		profileHosts = append(profileHosts, p.Hosts...)
+	}
+	for _, fe := range frontends {
+		for _, svc := range fe.Services {
+			if fe.Port == "443" && stringutil.Contains(profileHosts, svc.Host) {
+				continue
+			}
+			if hasClientAuth(svc.Host) {
+				fe.ClientCA = ca
+			}
+		}
+	}
+}
+
+// parseHSTS returns the value of a Strict-Transport-Security header, empty if
+// the header is disabled.
+func parseHSTS(h *aci.HSTS) string {
+	if h.Disabled {
+		return ""
+	}
+	maxAge := defaultHSTSMaxAge
+	if h.MaxAge > 0 {
+		maxAge = h.MaxAge
+	}
+	value := "max-age=" + strconv.Itoa(maxAge)
+	if h.IncludeSubDomains {
+		value += "; includeSubDomains"
+	}
+	if h.Preload {
+		if !h.IncludeSubDomains || maxAge < 31536000 {
+			log.Warningln("HSTS preload lists require includeSubDomains and a max-age of at least 31536000")
+		}
+		value += "; preload"
+	}
+	return value
+}
+
+// parseTLSProfile returns the TLS profile of a TLS entry with its own
+// options, nil if the entry uses the ingress wide options.
+func parseTLSProfile(tls aci.ExtendedIngressTLS, index int) *TLSProfile {
+	if tls.Options == nil {
+		return nil
+	}
+	if tls.SecretName == "" || len(tls.Hosts) == 0 {
+		log.Warningln("Ignoring TLS options of hosts", tls.Hosts, "cause they require hosts and a secretName")
+		return nil
+	}
+	if tls.Options.DHParamSize != 0 {
+		log.Warningln("Ignoring dhParamSize of hosts", tls.Hosts, "cause it only applies ingress wide")
+	}
+	return &TLSProfile{
+		Name:       "https-tls-" + strconv.Itoa(index),
+		Hosts:      tls.Hosts,
+		SecretName: tls.SecretName,
+		TLS:        parseTLSOptions(tls.Options, GetLoadbalancerImage()),
+	}
+}
+
+// parseTLSOptions returns the settings of TLS terminating binds, starting from
+// a preset. Settings the HAProxy version of the load balancer image does not
+// support fall back to the preset.
+func parseTLSOptions(o *aci.TLSOptions, image string) *TLSOptions {
+	if o == nil {
+		o = &aci.TLSOptions{}
+	}
+	preset, ok := tlsPresets[o.Preset]
+	if !ok {
+		if o.Preset != "" {
+			log.Warningln("Invalid TLS preset", o.Preset, "using", aci.TLSPresetIntermediate)
+		}
+		preset = tlsPresets[aci.TLSPresetIntermediate]
+	}
+	version, known := imageHAProxyVersion(image)
+
+	minVersion := preset.minVersion
+	if o.MinVersion != "" {
+		valid := false
+		for _, v := range tlsVersions[1:] {
+			if v.name != o.MinVersion {
+				continue
+			}
+			valid = true
+			if known && !version.atLeast(v.since) {
+				log.Warningln("TLS version", v.name, "is not supported by", image, "using", minVersion)
+			} else {
+				minVersion = v.name
+			}
+		}
+		if !valid {
+			log.Warningln("Invalid minimum TLS version", o.MinVersion, "using", minVersion)
+		}
+	}
+	bind := make([]string, 0)
+	for _, v := range tlsVersions {
+		if v.name == minVersion {
+			break
+		}
+		bind = append(bind, v.disable)
+	}
+	if !o.SessionTickets {
+		bind = append(bind, "no-tls-tickets")
+	}
+	if o.Curves != "" {
+		if strings.ContainsAny(o.Curves, " \t\n") {
+			log.Warningln("Ignoring invalid TLS curves", o.Curves)
+		} else if !known || version.atLeast(haproxyVersion{1, 8}) {
+			bind = append(bind, "curves "+o.Curves)
+		} else {
+			curve := strings.Split(o.Curves, ":")[0]
+			if curve != o.Curves {
+				log.Warningln(image, "supports a single ECDHE curve, using", curve)
+			}
+			bind = append(bind, "ecdhe "+curve)
+		}
+	}
+
+	opts := &TLSOptions{
+		BindOptions: strings.Join(bind, " "),
+		Ciphers:     preset.ciphers,
+		DHParamSize: defaultDHParamSize,
+		ALPN:        "http/1.1",
+	}
+	if o.HTTP2 {
+		if known && !version.atLeast(http2FrontendSince) {
+			log.Warningln("HTTP/2 is not supported by", image, "using http/1.1")
+		} else {
+			opts.ALPN = "h2,http/1.1"
+		}
+	}
+	if o.Ciphers != "" {
+		if strings.ContainsAny(o.Ciphers, " \t\n") {
+			log.Warningln("Ignoring invalid TLS ciphers", o.Ciphers)
+		} else {
+			opts.Ciphers = o.Ciphers
+		}
+	}
+	switch o.DHParamSize {
+	case 0:
+	case 1024, 2048, 4096:
+		opts.DHParamSize = o.DHParamSize
+	default:
+		log.Warningln("Invalid DH param size", o.DHParamSize, "using", opts.DHParamSize)
+	}
+	return opts
+}
+
+// parseBackendH2 checks whether a backend speaks h2 to its servers.
+func parseBackendH2(protocol, image string) bool {
+	switch protocol {
+	case "", aci.BackendProtocolHTTP1:
+		return false
+	case aci.BackendProtocolH2:
+		if v, known := imageHAProxyVersion(image); known && !v.atLeast(http2BackendSince) {
+			log.Warningln("h2 to backends is not supported by", image, "using http/1.1")
+			return false
+		}
+		return true
+	}
+	log.Warningln("Invalid backend protocol", protocol, "using", aci.BackendProtocolHTTP1)
+	return false
+}
+
+// parseSendProxy returns the server option sending a PROXY protocol header of
+// the given version to the servers of a backend.
+func parseSendProxy(version string) string {
+	switch version {
+	case "":
+		return ""
+	case aci.ProxyProtocolV1:
+		return "send-proxy"
+	case aci.ProxyProtocolV2:
+		return "send-proxy-v2"
+	}
+	log.Warningln("Invalid PROXY protocol version", version, "not sending PROXY protocol to backend")
+	return ""
+}
+
+// parseUpstreamTLS returns the TLS settings of the connections to the servers
+// of a backend and marks the referenced Secrets to be mounted in the HAProxy
+// pods. Secrets missing the expected keys are ignored.
+func (lbc *EngressController) parseUpstreamTLS(u *aci.UpstreamTLS) *UpstreamTLS {
+	if u == nil {
+		return nil
+	}
+	tls := &UpstreamTLS{
+		SNI: strings.TrimSpace(u.SNI),
+	}
+	if u.CASecretName != "" {
+		if lbc.hasSecretKeys(u.CASecretName, "ca.crt") {
+			tls.CAFile = upstreamSecretsPath + u.CASecretName + "/ca.crt"
+			tls.VerifyHost = strings.TrimSpace(u.VerifyHost)
+			lbc.addUpstreamTLSSecret(u.CASecretName)
+		} else {
+			log.Warningln("Skipping upstream CA verification, secret", u.CASecretName, "has no ca.crt")
+		}
+	} else if u.VerifyHost != "" {
+		log.Warningln("Ignoring upstream verifyHost", u.VerifyHost, "without a CA secret")
+	}
+	if u.ClientSecretName != "" {
+		if lbc.hasSecretKeys(u.ClientSecretName, "tls.crt", "tls.key") {
+			tls.CrtFile = upstreamCertsPath + u.ClientSecretName + ".pem"
+			lbc.addUpstreamTLSSecret(u.ClientSecretName)
+		} else {
+			log.Warningln("Skipping upstream client certificate, secret", u.ClientSecretName, "has no tls.crt or tls.key")
+		}
+	}
+	return tls
+}
+
+// hasSecretKeys checks whether a Secret of the ingress namespace holds all keys.
+func (lbc *EngressController) hasSecretKeys(name string, keys ...string) bool {
+	secret, err := lbc.KubeClient.Core().Secrets(lbc.Config.Namespace).Get(name)
+	if err != nil {
+		log.Errorln("Error encountered while loading secret", name, err)
+		return false
+	}
+	for _, key := range keys {
+		if _, ok := secret.Data[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (lbc *EngressController) addUpstreamTLSSecret(name string) {
+	if ok, _ := arrays.Contains(lbc.Options.UpstreamTLSSecrets, name); !ok {
+		lbc.Options.UpstreamTLSSecrets = append(lbc.Options.UpstreamTLSSecrets, name)
+	}
+}
+
+// parseBalance validates the load balancing algorithm and hash type of a
+// backend against the HAProxy version of the load balancer image. Invalid
+// settings fall back to the HAProxy defaults.
+func parseBalance(balance, hashType, image string, http bool) (string, string) {
+	balance = strings.TrimSpace(balance)
+	if balance == "" {
+		if hashType != "" {
+			log.Warningln("Ignoring hash type", hashType, "without a hashing balance algorithm")
+		}
+		return "", ""
+	}
+	name, ok := balanceAlgorithmName(balance)
+	if !ok {
+		log.Warningln("Invalid balance algorithm", balance, "using roundrobin")
+		return "", ""
+	}
+	algo := balanceAlgorithms[name]
+	if algo.httpOnly && !http {
+		log.Warningln("Balance algorithm", name, "is only supported for HTTP backends, using roundrobin")
+		return "", ""
+	}
+	if v, known := imageHAProxyVersion(image); known && !v.atLeast(algo.since) {
+		log.Warningln("Balance algorithm", name, "is not supported by", image, "using roundrobin")
+		return "", ""
+	}
+
+	switch hashType {
+	case "":
+	case "map-based", "consistent":
+		if !algo.hashing {
+			log.Warningln("Ignoring hash type", hashType, "of non hashing balance algorithm", name)
+			hashType = ""
+		}
+	default:
+		log.Warningln("Invalid hash type", hashType, "using map-based")
+		hashType = ""
+	}
+	return balance, hashType
+}
+
+// parseSessionAffinity returns the session affinity of a backend. Backends
+// without own settings use a SERVERID cookie for http and the source ip for
+// tcp if sticky sessions are enabled for the whole ingress.
+func parseSessionAffinity(sa *aci.SessionAffinity, sticky bool, http bool) *SessionAffinity {
+	if sa == nil {
+		if !sticky {
+			return nil
+		}
+		sa = &aci.SessionAffinity{}
+	}
+
+	affinity := &SessionAffinity{}
+	kind := sa.Type
+	if kind == "" {
+		kind = aci.SessionAffinityCookie
+	}
+	if !http && kind != aci.SessionAffinitySourceIP {
+		if sa.Type != "" {
+			log.Warningln("Session affinity", sa.Type, "is not supported for tcp backends, using", aci.SessionAffinitySourceIP)
+		}
+		kind = aci.SessionAffinitySourceIP
+	}
+
+	switch kind {
+	case aci.SessionAffinityCookie:
+		affinity.CookieName = "SERVERID"
+		if sa.CookieName != "" {
+			affinity.CookieName = sa.CookieName
+		}
+		affinity.CookieMode = "insert"
+		switch sa.CookieMode {
+		case "", "insert":
+		case "rewrite", "prefix":
+			affinity.CookieMode = sa.CookieMode
+		default:
+			log.Warningln("Invalid cookie mode", sa.CookieMode, "using", affinity.CookieMode)
+		}
+		if sa.CookieMaxAge != "" {
+			if affinity.CookieMode == "insert" && timeoutFormat.MatchString(sa.CookieMaxAge) {
+				affinity.CookieMaxLife = sa.CookieMaxAge
+			} else {
+				log.Warningln("Ignoring cookie max age", sa.CookieMaxAge, "of", affinity.CookieMode, "cookie")
+			}
+		}
+	case aci.SessionAffinitySourceIP:
+		affinity.StickTableType = "ip"
+		affinity.StickOn = "src"
+	case aci.SessionAffinityHeader:
+		if sa.HeaderName == "" || strings.ContainsAny(sa.HeaderName, " ()") {
+			log.Warningln("Invalid session affinity header", sa.HeaderName, "ignoring session affinity")
+			return nil
+		}
+		affinity.StickTableType = "string len 64"
+		affinity.StickOn = "req.hdr(" + sa.HeaderName + ")"
+	default:
+		log.Warningln("Invalid session affinity", sa.Type, "ignoring session affinity")
+		return nil
+	}
+	return affinity
+}
+
+// parseRateLimit converts the rate limit of the spec, nil if unlimited.
+func parseRateLimit(rl *aci.RateLimit) *RateLimit {
+	if rl == nil || (rl.RequestsPerSecond <= 0 && rl.ConnectionsPerSecond <= 0 && rl.Connections <= 0) {
+		return nil
+	}
+	limit := &RateLimit{}
+	if rl.RequestsPerSecond > 0 {
+		limit.RequestsPerSecond = rl.RequestsPerSecond
+	}
+	if rl.ConnectionsPerSecond > 0 {
+		limit.ConnectionsPerSecond = rl.ConnectionsPerSecond
+	}
+	if rl.Connections > 0 {
+		limit.Connections = rl.Connections
+	}
+	switch rl.Response {
+	case "", aci.RateLimitResponseDeny:
+	case aci.RateLimitResponseTarpit:
+		limit.Tarpit = true
+	default:
+		log.Warningln("Invalid rate limit response", rl.Response, "using", aci.RateLimitResponseDeny)
+	}
+	return limit
+}
+
+// parseHealthCheck fills in the defaults of a backend health check.
+func parseHealthCheck(hc *aci.HealthCheck) *HealthCheck {
+	if hc == nil {
+		return nil
+	}
+	check := &HealthCheck{
+		Method:       "GET",
+		Path:         hc.Path,
+		ExpectStatus: hc.ExpectStatus,
+		Interval:     "2s",
+		Rise:         2,
+		Fall:         3,
+		SSL:          hc.SSL,
+	}
+	if hc.Method != "" {
+		if method := strings.ToUpper(hc.Method); healthCheckMethod.MatchString(method) {
+			check.Method = method
+		} else {
+			log.Warningln("Invalid health check method", hc.Method, "using", check.Method)
+		}
+	}
+	if hc.Path != "" && (!strings.HasPrefix(hc.Path, "/") || strings.IndexFunc(hc.Path, unicode.IsSpace) >= 0) {
+		log.Warningln("Invalid health check path", hc.Path, "using the default")
+		check.Path = ""
+	}
+	if check.Path == "" && check.ExpectStatus > 0 {
+		// the status can only be checked with an http request
+		check.Path = "/"
+	}
+	if hc.Interval != "" {
+		if timeoutFormat.MatchString(hc.Interval) {
+			check.Interval = hc.Interval
+		} else {
+			log.Warningln("Invalid health check interval", hc.Interval, "using", check.Interval)
+		}
+	}
+	if hc.Rise > 0 {
+		check.Rise = hc.Rise
+	}
+	if hc.Fall > 0 {
+		check.Fall = hc.Fall
+	}
+	return check
+}
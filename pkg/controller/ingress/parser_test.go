@@ -1,9 +1,17 @@
 package ingress
 
 import (
+	"sort"
+	"strings"
 	"testing"
 
+	aci "github.com/appscode/k8s-addons/api"
+	"github.com/appscode/k8s-addons/pkg/stash"
 	"github.com/stretchr/testify/assert"
+	kapi "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/cache"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/fake"
+	"k8s.io/kubernetes/pkg/util/intstr"
 )
 
 func TestNodeSelector(t *testing.T) {
@@ -67,7 +75,976 @@ func TestIsIncludeAbleOptions(t *testing.T) {
 
 	for k, v := range dataTable {
 		for key, val := range v {
-			assert.Equal(t, key, isIncludeAbleAddress(val, k))
+			assert.Equal(t, key, isForwardable(val, k))
 		}
 	}
 }
+
+func TestPathMatch(t *testing.T) {
+	dataTable := map[string]map[string]string{
+		"/api": {
+			"":              aci.PathMatchPrefix,
+			"Prefix":        aci.PathMatchPrefix,
+			"SegmentPrefix": aci.PathMatchSegmentPrefix,
+			"Exact":         aci.PathMatchExact,
+			"Regex":         aci.PathMatchRegex,
+			"Begin":         "",
+		},
+		"/api/(v1": {
+			"Prefix": aci.PathMatchPrefix,
+			"Regex":  "",
+		},
+		"/api v1": {
+			"Prefix": "",
+			"Exact":  "",
+			"Regex":  "",
+		},
+	}
+
+	for path, v := range dataTable {
+		for match, exp := range v {
+			res, err := parsePathMatch(path, match)
+			assert.Equal(t, exp, res)
+			assert.Equal(t, exp == "", err != nil)
+		}
+	}
+}
+
+func TestServicesByPriority(t *testing.T) {
+	svcs := []*Service{
+		{Name: "root", AclMatch: "/"},
+		{Name: "foo-root", Host: "foo.com", AclMatch: "/"},
+		{Name: "wildcard-api", Host: "*.foo.com", AclMatch: "/api"},
+		{Name: "foo-api", Host: "foo.com", AclMatch: "/api", PathMatch: aci.PathMatchPrefix},
+		{Name: "foo-api-exact", Host: "foo.com", AclMatch: "/api", PathMatch: aci.PathMatchExact},
+		{Name: "foo-api-canary", Host: "foo.com", AclMatch: "/api", PathMatch: aci.PathMatchPrefix, Matches: []string{"req.cook(canary) -m found"}},
+		{Name: "foo-regex-short", Host: "foo.com", AclMatch: "^/a", PathMatch: aci.PathMatchRegex},
+		{Name: "foo-api-v1", Host: "foo.com", AclMatch: "/api/v1"},
+		{Name: "foo", Host: "foo.com"},
+		{Name: "foo-regex-long", Host: "foo.com", AclMatch: "^/api/v[0-9]+/users$", PathMatch: aci.PathMatchRegex},
+		{Name: "bar", Host: "bar.com", AclMatch: "/"},
+	}
+	sort.Stable(servicesByPriority(svcs))
+
+	names := make([]string, 0)
+	for _, svc := range svcs {
+		names = append(names, svc.Name)
+	}
+	assert.Equal(t, []string{"bar", "foo-api-v1", "foo-api-exact", "foo-api-canary", "foo-api", "foo-regex-short", "foo-regex-long", "foo-root", "foo", "wildcard-api", "root"}, names)
+}
+
+func TestRegexPathAfterRootPath(t *testing.T) {
+	svcs := []*Service{
+		{Name: "root-prefix", Host: "foo.com", AclMatch: "/", PathMatch: aci.PathMatchPrefix},
+		{Name: "root-segment", Host: "foo.com", AclMatch: "/", PathMatch: aci.PathMatchSegmentPrefix},
+		{Name: "root-exact", Host: "foo.com", AclMatch: "/", PathMatch: aci.PathMatchExact},
+		{Name: "regex", Host: "foo.com", AclMatch: "^/users/[0-9]+$", PathMatch: aci.PathMatchRegex},
+	}
+	sort.Stable(servicesByPriority(svcs))
+
+	names := make([]string, 0)
+	for _, svc := range svcs {
+		names = append(names, svc.Name)
+	}
+	assert.Equal(t, []string{"root-exact", "regex", "root-segment", "root-prefix"}, names)
+	assert.Empty(t, unreachableServices(svcs))
+}
+
+func TestUnreachableServices(t *testing.T) {
+	svcs := []*Service{
+		{Name: "a", Host: "foo.com", AclMatch: "/api", PathMatch: aci.PathMatchPrefix},
+		{Name: "b", Host: "foo.com", AclMatch: "/api", PathMatch: aci.PathMatchPrefix},
+		{Name: "c", Host: "bar.com", AclMatch: "/api", PathMatch: aci.PathMatchPrefix},
+		{Name: "d", Host: "bar.com", AclMatch: "api", PathMatch: aci.PathMatchPrefix},
+		{Name: "e", Host: "bar.com", AclMatch: "api$", PathMatch: aci.PathMatchRegex},
+		{Name: "f", Host: "foo.com", AclMatch: "/api", PathMatch: aci.PathMatchPrefix, Matches: []string{"method GET"}},
+	}
+	names := make([]string, 0)
+	for _, svc := range unreachableServices(svcs) {
+		names = append(names, svc.Name)
+	}
+	assert.Equal(t, []string{"b", "d"}, names)
+}
+
+func TestTuningAnnotations(t *testing.T) {
+	opts := annotation{}
+	assert.Equal(t, 4000, opts.MaxConn())
+	assert.Equal(t, "50000", opts.Timeout(TimeoutServer))
+	assert.Equal(t, "http-server-close", opts.ConnectionMode())
+	assert.Equal(t, "", opts.HTTPReuse())
+
+	opts = annotation{
+		MaxConn:        "20000",
+		TimeoutServer:  "5m",
+		TimeoutClient:  "30",
+		ConnectionMode: "tunnel",
+		HTTPReuse:      "safe",
+	}
+	assert.Equal(t, 20000, opts.MaxConn())
+	assert.Equal(t, "5m", opts.Timeout(TimeoutServer))
+	assert.Equal(t, "30", opts.Timeout(TimeoutClient))
+	assert.Equal(t, "http-tunnel", opts.ConnectionMode())
+	assert.Equal(t, "safe", opts.HTTPReuse())
+
+	opts = annotation{
+		MaxConn:        "-1",
+		TimeoutServer:  "5 minutes",
+		ConnectionMode: "close",
+		HTTPReuse:      "sometimes",
+	}
+	assert.Equal(t, 4000, opts.MaxConn())
+	assert.Equal(t, "50000", opts.Timeout(TimeoutServer))
+	assert.Equal(t, "http-server-close", opts.ConnectionMode())
+	assert.Equal(t, "", opts.HTTPReuse())
+}
+
+func TestParseHealthCheck(t *testing.T) {
+	assert.Nil(t, parseHealthCheck(nil))
+
+	assert.Equal(t, &HealthCheck{
+		Method:   "GET",
+		Interval: "2s",
+		Rise:     2,
+		Fall:     3,
+	}, parseHealthCheck(&aci.HealthCheck{}))
+
+	assert.Equal(t, &HealthCheck{
+		Method:       "HEAD",
+		Path:         "/healthz",
+		ExpectStatus: 204,
+		Interval:     "2s",
+		Rise:         1,
+		Fall:         5,
+	}, parseHealthCheck(&aci.HealthCheck{
+		Method:       "head",
+		Path:         "/healthz",
+		ExpectStatus: 204,
+		Interval:     "every 2 seconds",
+		Rise:         1,
+		Fall:         5,
+	}))
+
+	// the expected status is checked on / without a path
+	check := parseHealthCheck(&aci.HealthCheck{ExpectStatus: 200})
+	assert.Equal(t, "/", check.Path)
+	assert.Equal(t, 200, check.ExpectStatus)
+	check = parseHealthCheck(&aci.HealthCheck{Path: "healthz", ExpectStatus: 200})
+	assert.Equal(t, "/", check.Path)
+
+	// values breaking the option line fall back to the defaults
+	for _, hc := range []*aci.HealthCheck{
+		{Method: "GET /x HTTP/1.1"},
+		{Path: "/healthz HTTP/1.1"},
+		{Path: "/healthz\n    http-request deny"},
+		{Path: "healthz"},
+	} {
+		check := parseHealthCheck(hc)
+		assert.Equal(t, "GET", check.Method)
+		assert.Equal(t, "", check.Path)
+	}
+}
+
+func TestSSLRedirectAnnotations(t *testing.T) {
+	opts := annotation{}
+	assert.False(t, opts.SSLRedirect())
+	assert.Equal(t, 301, opts.SSLRedirectCode())
+
+	opts = annotation{
+		SSLRedirect:     "true",
+		SSLRedirectCode: "308",
+	}
+	assert.True(t, opts.SSLRedirect())
+	assert.Equal(t, 308, opts.SSLRedirectCode())
+
+	opts = annotation{
+		SSLRedirectCode: "307",
+	}
+	assert.Equal(t, 301, opts.SSLRedirectCode())
+}
+
+func TestParseHTPasswd(t *testing.T) {
+	users := parseHTPasswd(map[string][]byte{
+		"ops":  []byte("# operators\ncarol:$apr1$x1$y2\n\n"),
+		"auth": []byte("alice:$2y$05$abc\nbob:$6$salt$hash\ninvalid\nspace: pass\n"),
+	})
+	assert.Equal(t, []*User{
+		{Name: "alice", Password: "$2y$05$abc"},
+		{Name: "bob", Password: "$6$salt$hash"},
+		{Name: "carol", Password: "$apr1$x1$y2"},
+	}, users)
+}
+
+func TestParseSourceRange(t *testing.T) {
+	assert.Nil(t, parseSourceRange("a.com", nil, []string{}))
+
+	assert.Equal(t, &SourceRange{
+		Host:      "a.com",
+		Whitelist: []string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"},
+		Blacklist: []string{},
+	}, parseSourceRange("a.com", []string{"10.0.0.0/8", " 192.168.1.1", "fd00::/8", "10.0.0.0/33", "host"}, nil))
+
+	assert.Equal(t, &SourceRange{
+		Whitelist: []string{"0.0.0.0/32"},
+		Blacklist: []string{"1.2.3.4"},
+	}, parseSourceRange("", []string{"invalid"}, []string{"1.2.3.4"}))
+
+	opts := annotation{
+		WhitelistSourceRange: "10.0.0.0/8, 192.168.0.0/16,",
+	}
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.0.0/16"}, opts.WhitelistSourceRange())
+	assert.Equal(t, []string{}, opts.BlacklistSourceRange())
+}
+
+func TestParseRateLimit(t *testing.T) {
+	assert.Nil(t, parseRateLimit(nil))
+	assert.Nil(t, parseRateLimit(&aci.RateLimit{Response: "tarpit"}))
+
+	assert.Equal(t, &RateLimit{
+		RequestsPerSecond: 10,
+		Connections:       5,
+		Tarpit:            true,
+	}, parseRateLimit(&aci.RateLimit{
+		RequestsPerSecond:    10,
+		ConnectionsPerSecond: -1,
+		Connections:          5,
+		Response:             "tarpit",
+	}))
+
+	assert.Equal(t, &RateLimit{ConnectionsPerSecond: 3}, parseRateLimit(&aci.RateLimit{
+		ConnectionsPerSecond: 3,
+		Response:             "503",
+	}))
+
+	opts := annotation{}
+	assert.Nil(t, opts.RateLimit())
+
+	opts = annotation{
+		RateLimitRequestsPerSecond: "100",
+		RateLimitConnections:       "ten",
+		RateLimitResponse:          "429",
+	}
+	assert.Equal(t, &aci.RateLimit{
+		RequestsPerSecond: 100,
+		Response:          "429",
+	}, opts.RateLimit())
+}
+
+func TestParseErrorFiles(t *testing.T) {
+	lbc := &EngressController{
+		KubeClient: fake.NewSimpleClientset(&kapi.ConfigMap{
+			ObjectMeta: kapi.ObjectMeta{
+				Name:      "error-pages",
+				Namespace: "default",
+			},
+			Data: map[string]string{
+				"503.http": "HTTP/1.0 503 Service Unavailable",
+				"404.http": "HTTP/1.0 404 Not Found",
+				"403.http": "HTTP/1.0 403 Forbidden",
+			},
+		}),
+		Config: &aci.Ingress{
+			ObjectMeta: kapi.ObjectMeta{
+				Name:      "foo",
+				Namespace: "default",
+			},
+		},
+		Options: &KubeOptions{
+			ErrorFilesConfigMaps: make([]string, 0),
+		},
+	}
+
+	assert.Nil(t, lbc.parseErrorFiles(""))
+	assert.Nil(t, lbc.parseErrorFiles("missing"))
+	assert.Empty(t, lbc.Options.ErrorFilesConfigMaps)
+
+	expected := []*ErrorFile{
+		{Code: "403", Path: "/srv/haproxy/errorfiles/error-pages/403.http"},
+		{Code: "503", Path: "/srv/haproxy/errorfiles/error-pages/503.http"},
+	}
+	assert.Equal(t, expected, lbc.parseErrorFiles("error-pages"))
+	assert.Equal(t, expected, lbc.parseErrorFiles("error-pages"))
+	assert.Equal(t, []string{"error-pages"}, lbc.Options.ErrorFilesConfigMaps)
+
+	assert.Equal(t, []kapi.VolumeMount{
+		{Name: "error-pages-configmap-volume", MountPath: "/srv/haproxy/errorfiles/error-pages"},
+	}, VolumeMounts(lbc.Options))
+}
+
+func TestParseBalance(t *testing.T) {
+	image := "appscode/haproxy:1.7.5-1.5.5"
+	dataTable := []struct {
+		balance, hashType string
+		image             string
+		http              bool
+		expected          []string
+	}{
+		{"", "consistent", image, true, []string{"", ""}},
+		{"leastconn", "", image, false, []string{"leastconn", ""}},
+		{"leastconn", "consistent", image, true, []string{"leastconn", ""}},
+		{"uri", "consistent", image, true, []string{"uri", "consistent"}},
+		{"uri", "", image, false, []string{"", ""}},
+		{"hdr(X-Tenant)", "jump", image, true, []string{"hdr(X-Tenant)", ""}},
+		{"random", "", image, true, []string{"", ""}},
+		{"random", "", "appscode/haproxy:1.9.0", true, []string{"random", ""}},
+		{"random", "", "appscode/haproxy:latest", true, []string{"random", ""}},
+		{"fastest", "", image, true, []string{"", ""}},
+	}
+	for _, d := range dataTable {
+		balance, hashType := parseBalance(d.balance, d.hashType, d.image, d.http)
+		assert.Equal(t, d.expected, []string{balance, hashType}, d.balance)
+	}
+}
+
+func TestParseSessionAffinity(t *testing.T) {
+	assert.Nil(t, parseSessionAffinity(nil, false, true))
+
+	assert.Equal(t, &SessionAffinity{
+		CookieName: "SERVERID",
+		CookieMode: "insert",
+	}, parseSessionAffinity(nil, true, true))
+
+	assert.Equal(t, &SessionAffinity{
+		StickTableType: "ip",
+		StickOn:        "src",
+	}, parseSessionAffinity(nil, true, false))
+
+	assert.Equal(t, &SessionAffinity{
+		CookieName:    "CARTSRV",
+		CookieMode:    "insert",
+		CookieMaxLife: "2h",
+	}, parseSessionAffinity(&aci.SessionAffinity{
+		CookieName:   "CARTSRV",
+		CookieMaxAge: "2h",
+	}, false, true))
+
+	assert.Equal(t, &SessionAffinity{
+		CookieName: "JSESSIONID",
+		CookieMode: "prefix",
+	}, parseSessionAffinity(&aci.SessionAffinity{
+		CookieName:   "JSESSIONID",
+		CookieMode:   "prefix",
+		CookieMaxAge: "2h",
+	}, false, true))
+
+	assert.Equal(t, &SessionAffinity{
+		StickTableType: "string len 64",
+		StickOn:        "req.hdr(X-Session-Id)",
+	}, parseSessionAffinity(&aci.SessionAffinity{
+		Type:       aci.SessionAffinityHeader,
+		HeaderName: "X-Session-Id",
+	}, true, true))
+
+	assert.Equal(t, &SessionAffinity{
+		StickTableType: "ip",
+		StickOn:        "src",
+	}, parseSessionAffinity(&aci.SessionAffinity{
+		Type:       aci.SessionAffinityHeader,
+		HeaderName: "X-Session-Id",
+	}, false, false))
+
+	assert.Nil(t, parseSessionAffinity(&aci.SessionAffinity{Type: aci.SessionAffinityHeader}, true, true))
+	assert.Nil(t, parseSessionAffinity(&aci.SessionAffinity{Type: "Random"}, true, true))
+}
+
+func TestGroupTCPServices(t *testing.T) {
+	plain := &TCPService{Name: "plain", Port: "5432"}
+	wildcard := &TCPService{Name: "wildcard", Port: "8443", Host: "*.example.com"}
+	kafka := &TCPService{Name: "kafka", Port: "8443", Host: "kafka.example.com"}
+	fallback := &TCPService{Name: "fallback", Port: "8443"}
+	duplicate := &TCPService{Name: "duplicate", Port: "8443", Host: "kafka.example.com"}
+	a := &TCPService{Name: "a", Port: "9000", Host: "a.example.com", SecretName: "a-tls", ALPNOptions: "alpn h2"}
+	b := &TCPService{Name: "b", Port: "9000", Host: "b.example.com", SecretName: "b-tls", ALPNOptions: "alpn http/1.1"}
+	passthrough := &TCPService{Name: "passthrough", Port: "9000", Host: "c.example.com"}
+
+	frontends := groupTCPServices([]*TCPService{plain, wildcard, kafka, fallback, duplicate, a, b, passthrough})
+	assert.Equal(t, []*TCPFrontend{
+		{
+			Port:           "5432",
+			SecretNames:    []string{},
+			Services:       []*TCPService{},
+			DefaultService: plain,
+		},
+		{
+			Port:           "8443",
+			SecretNames:    []string{},
+			SNI:            true,
+			Services:       []*TCPService{kafka, wildcard},
+			DefaultService: fallback,
+		},
+		{
+			Port:        "9000",
+			SecretNames: []string{"a-tls", "b-tls"},
+			ALPNOptions: "alpn h2",
+			Services:    []*TCPService{a, b},
+		},
+	}, frontends)
+}
+
+func TestPassthroughHTTPS(t *testing.T) {
+	assert.False(t, passthroughHTTPS([]*TCPFrontend{{Port: "5432"}}))
+
+	secure := &TCPService{Name: "secure", Port: "443", Host: "secure.example.com"}
+	plain := &TCPService{Name: "plain", Port: "443"}
+	frontends := groupTCPServices([]*TCPService{secure, plain})
+	assert.True(t, passthroughHTTPS(frontends))
+	assert.Equal(t, []*TCPFrontend{
+		{
+			Port:        "443",
+			SecretNames: []string{},
+			SNI:         true,
+			Services:    []*TCPService{secure},
+			HTTPS:       true,
+		},
+	}, frontends)
+}
+
+func TestParseUpstreamTLS(t *testing.T) {
+	lbc := &EngressController{
+		KubeClient: fake.NewSimpleClientset(
+			&kapi.Secret{
+				ObjectMeta: kapi.ObjectMeta{Name: "upstream-ca", Namespace: "default"},
+				Data:       map[string][]byte{"ca.crt": []byte("ca")},
+			},
+			&kapi.Secret{
+				ObjectMeta: kapi.ObjectMeta{Name: "upstream-client", Namespace: "default"},
+				Data:       map[string][]byte{"tls.crt": []byte("crt"), "tls.key": []byte("key")},
+			},
+		),
+		Config: &aci.Ingress{
+			ObjectMeta: kapi.ObjectMeta{
+				Name:      "foo",
+				Namespace: "default",
+			},
+		},
+		Options: &KubeOptions{
+			UpstreamTLSSecrets: make([]string, 0),
+		},
+	}
+
+	assert.Nil(t, lbc.parseUpstreamTLS(nil))
+	assert.Equal(t, &UpstreamTLS{}, lbc.parseUpstreamTLS(&aci.UpstreamTLS{VerifyHost: "api.svc"}))
+	assert.Equal(t, &UpstreamTLS{}, lbc.parseUpstreamTLS(&aci.UpstreamTLS{
+		CASecretName:     "upstream-client",
+		ClientSecretName: "upstream-ca",
+	}))
+	assert.Empty(t, lbc.Options.UpstreamTLSSecrets)
+
+	assert.Equal(t, &UpstreamTLS{
+		CAFile:     "/srv/haproxy/upstream/upstream-ca/ca.crt",
+		CrtFile:    "/etc/ssl/private/upstream/upstream-client.pem",
+		SNI:        "api.svc",
+		VerifyHost: "api.svc",
+	}, lbc.parseUpstreamTLS(&aci.UpstreamTLS{
+		CASecretName:     "upstream-ca",
+		ClientSecretName: "upstream-client",
+		SNI:              "api.svc",
+		VerifyHost:       "api.svc",
+	}))
+	assert.Equal(t, []string{"upstream-ca", "upstream-client"}, lbc.Options.UpstreamTLSSecrets)
+
+	assert.Equal(t, []kapi.VolumeMount{
+		{Name: "upstream-ca-upstream-volume", MountPath: "/srv/haproxy/upstream/upstream-ca"},
+		{Name: "upstream-client-upstream-volume", MountPath: "/srv/haproxy/upstream/upstream-client"},
+	}, VolumeMounts(lbc.Options))
+}
+
+func TestParseClientAuth(t *testing.T) {
+	lbc := &EngressController{
+		KubeClient: fake.NewSimpleClientset(
+			&kapi.Secret{
+				ObjectMeta: kapi.ObjectMeta{Name: "partner-ca", Namespace: "default"},
+				Data:       map[string][]byte{"ca.crt": []byte("ca"), "ca.crl": []byte("crl")},
+			},
+			&kapi.Secret{
+				ObjectMeta: kapi.ObjectMeta{Name: "internal-ca", Namespace: "default"},
+				Data:       map[string][]byte{"ca.crt": []byte("ca")},
+			},
+		),
+		Config: &aci.Ingress{
+			ObjectMeta: kapi.ObjectMeta{
+				Name:      "foo",
+				Namespace: "default",
+			},
+		},
+		Options: &KubeOptions{
+			ClientCASecrets: make([]string, 0),
+		},
+	}
+
+	assert.Nil(t, lbc.parseClientAuth(nil, nil))
+	// a missing CA rejects all clients, unless verification is optional
+	assert.Equal(t, &ClientAuth{Hosts: []string{"api.example.com"}, Required: true},
+		lbc.parseClientAuth(&aci.ClientAuth{CASecretName: "missing"}, []string{"api.example.com"}))
+	assert.Nil(t, lbc.parseClientAuth(&aci.ClientAuth{CASecretName: "missing", Verify: "optional"}, nil))
+	assert.Empty(t, lbc.Options.ClientCASecrets)
+
+	partner := lbc.parseClientAuth(&aci.ClientAuth{CASecretName: "partner-ca"}, []string{"api.example.com"})
+	assert.Equal(t, &ClientAuth{
+		Hosts:    []string{"api.example.com"},
+		CAFile:   "/srv/haproxy/client-ca/partner-ca/ca.crt",
+		CRLFile:  "/srv/haproxy/client-ca/partner-ca/ca.crl",
+		Required: true,
+	}, partner)
+	internal := lbc.parseClientAuth(&aci.ClientAuth{CASecretName: "internal-ca", Verify: "optional"}, nil)
+	assert.Equal(t, &ClientAuth{CAFile: "/srv/haproxy/client-ca/internal-ca/ca.crt"}, internal)
+	assert.Equal(t, []string{"partner-ca", "internal-ca"}, lbc.Options.ClientCASecrets)
+
+	assert.Nil(t, clientCA([]*ClientAuth{{Required: true}}, "https hosts"))
+	assert.Equal(t, partner, clientCA([]*ClientAuth{{Required: true}, partner, internal}, "https hosts"))
+}
+
+func TestSetClientCA(t *testing.T) {
+	ca := &ClientAuth{Hosts: []string{"partner.example.com", "admin.example.com"}, CAFile: "/srv/haproxy/client-ca/partner-ca/ca.crt", Required: true}
+	auths := []*ClientAuth{ca, {Hosts: []string{"app.example.com"}, Required: true}}
+	frontends := groupHTTPServices([]*Service{
+		{Name: "partner", Host: "partner.example.com", Port: "443"},
+		{Name: "app", Host: "app.example.com", Port: "443"},
+		{Name: "admin", Host: "admin.example.com", Port: "443"},
+		{Name: "app-8443", Host: "app.example.com", Port: "8443"},
+	}, "https-frontend", "443")
+	profiles := []*TLSProfile{
+		{Name: "tls-profile-1", Hosts: []string{"partner.example.com"}},
+		{Name: "tls-profile-2", Hosts: []string{"legacy.example.com"}},
+	}
+
+	setClientCA(clientCA(auths, "https hosts"), auths, frontends, profiles)
+	assert.Equal(t, ca, profiles[0].ClientCA)
+	assert.Nil(t, profiles[1].ClientCA)
+	// admin.example.com has no profile and shares the bind on 443
+	assert.Equal(t, ca, frontends[0].ClientCA)
+	assert.Nil(t, frontends[1].ClientCA)
+
+	frontends[0].ClientCA = nil
+	profiles[0].ClientCA = nil
+	frontends[0].Services = frontends[0].Services[:2]
+	setClientCA(clientCA(auths, "https hosts"), auths, frontends, profiles)
+	assert.Equal(t, ca, profiles[0].ClientCA)
+	assert.Nil(t, frontends[0].ClientCA)
+}
+
+func TestParseTLSOptions(t *testing.T) {
+	image := "appscode/haproxy:1.7.5-1.5.5"
+	intermediate := parseTLSOptions(nil, image)
+	assert.Equal(t, "no-sslv3 no-tlsv10 no-tls-tickets", intermediate.BindOptions)
+	assert.Equal(t, 2048, intermediate.DHParamSize)
+	assert.Equal(t, intermediate, parseTLSOptions(&aci.TLSOptions{Preset: "unknown", DHParamSize: 512}, image))
+
+	modern := parseTLSOptions(&aci.TLSOptions{Preset: "modern", Curves: "X25519:P-256"}, image)
+	assert.Equal(t, "no-sslv3 no-tlsv10 no-tlsv11 no-tls-tickets ecdhe X25519", modern.BindOptions)
+	assert.NotContains(t, modern.Ciphers, ":DHE-")
+
+	old := parseTLSOptions(&aci.TLSOptions{Preset: "old", SessionTickets: true, Ciphers: "HIGH:!aNULL", DHParamSize: 4096}, image)
+	assert.Equal(t, &TLSOptions{BindOptions: "no-sslv3", Ciphers: "HIGH:!aNULL", DHParamSize: 4096, ALPN: "http/1.1"}, old)
+	// presets keep the default DH params
+	assert.Equal(t, 2048, parseTLSOptions(&aci.TLSOptions{Preset: "old"}, image).DHParamSize)
+	assert.Equal(t, 1024, parseTLSOptions(&aci.TLSOptions{Preset: "old", DHParamSize: 1024}, image).DHParamSize)
+
+	// TLSv1.3 and multiple curves need HAProxy 1.8
+	assert.Equal(t, "no-sslv3 no-tlsv10 no-tls-tickets", parseTLSOptions(&aci.TLSOptions{MinVersion: "TLSv1.3"}, image).BindOptions)
+	assert.Equal(t, "no-sslv3 no-tlsv10 no-tlsv11 no-tlsv12 no-tls-tickets curves X25519:P-256",
+		parseTLSOptions(&aci.TLSOptions{MinVersion: "TLSv1.3", Curves: "X25519:P-256"}, "appscode/haproxy:1.8.1").BindOptions)
+
+	assert.Nil(t, parseTLSProfile(aci.ExtendedIngressTLS{Hosts: []string{"old.example.com"}, SecretName: "old"}, 1))
+	assert.Nil(t, parseTLSProfile(aci.ExtendedIngressTLS{Hosts: []string{"old.example.com"}, Options: &aci.TLSOptions{Preset: "old"}}, 1))
+	profile := parseTLSProfile(aci.ExtendedIngressTLS{Hosts: []string{"old.example.com"}, SecretName: "old", Options: &aci.TLSOptions{Preset: "old"}}, 2)
+	assert.Equal(t, "https-tls-2", profile.Name)
+	assert.Equal(t, "no-sslv3 no-tls-tickets", profile.TLS.BindOptions)
+}
+
+func TestParseHSTS(t *testing.T) {
+	assert.Equal(t, "max-age=15768000", parseHSTS(annotation{}.HSTS()))
+	assert.Equal(t, "", parseHSTS(annotation{HSTSEnabled: "false"}.HSTS()))
+	assert.Equal(t, "max-age=31536000; includeSubDomains; preload", parseHSTS(annotation{
+		HSTSMaxAge:            "31536000",
+		HSTSIncludeSubDomains: "true",
+		HSTSPreload:           "true",
+	}.HSTS()))
+	assert.Equal(t, "max-age=300", parseHSTS(&aci.HSTS{MaxAge: 300}))
+
+	assert.True(t, annotation{}.SecureCookies())
+	assert.False(t, annotation{SecureCookies: "false"}.SecureCookies())
+}
+
+func TestParseHTTP2(t *testing.T) {
+	assert.Equal(t, "http/1.1", parseTLSOptions(nil, "appscode/haproxy:1.8.1").ALPN)
+	assert.Equal(t, "http/1.1", parseTLSOptions(&aci.TLSOptions{HTTP2: true}, "appscode/haproxy:1.7.5-1.5.5").ALPN)
+	assert.Equal(t, "h2,http/1.1", parseTLSOptions(&aci.TLSOptions{HTTP2: true}, "appscode/haproxy:1.8.1").ALPN)
+	assert.True(t, annotation{HTTP2: "true"}.TLSOptions().HTTP2)
+
+	assert.False(t, parseBackendH2("", "appscode/haproxy:2.0.1"))
+	assert.False(t, parseBackendH2("h2", "appscode/haproxy:1.8.1"))
+	assert.False(t, parseBackendH2("h3", "appscode/haproxy:2.0.1"))
+	assert.True(t, parseBackendH2("h2", "appscode/haproxy:2.0.1"))
+}
+
+func TestParseSendProxy(t *testing.T) {
+	assert.Equal(t, "", parseSendProxy(""))
+	assert.Equal(t, "send-proxy", parseSendProxy("v1"))
+	assert.Equal(t, "send-proxy-v2", parseSendProxy("v2"))
+	assert.Equal(t, "", parseSendProxy("v3"))
+	assert.True(t, annotation{AcceptProxy: "true"}.AcceptProxy())
+	assert.False(t, annotation{}.AcceptProxy())
+}
+
+func TestHTTPRulePorts(t *testing.T) {
+	http := aci.ExtendedIngressRuleValue{HTTP: &aci.HTTPExtendedIngressRuleValue{}}
+	lbc := &EngressController{
+		Config: &aci.Ingress{
+			Spec: aci.ExtendedIngressSpec{
+				Rules: []aci.ExtendedIngressRule{
+					{Host: "a.example.com", ExtendedIngressRuleValue: http},
+					{Host: "secure.example.com", ExtendedIngressRuleValue: http},
+					{Host: "admin.example.com", Port: intstr.FromInt(8080), ExtendedIngressRuleValue: http},
+					{Host: "secure.example.com", Port: intstr.FromInt(8443), ExtendedIngressRuleValue: http},
+					{Host: "b.example.com", Port: intstr.FromInt(8443), ExtendedIngressRuleValue: http},
+					{Host: "c.example.com", Port: intstr.FromInt(5432), ExtendedIngressRuleValue: http},
+					{Host: "pt.example.com", Port: intstr.FromInt(9443), ExtendedIngressRuleValue: http},
+					{
+						ExtendedIngressRuleValue: aci.ExtendedIngressRuleValue{
+							TCP: []aci.TCPExtendedIngressRuleValue{{Port: intstr.FromInt(5432)}},
+						},
+					},
+				},
+			},
+		},
+		Parsed:     &HAProxyOptions{},
+		HostFilter: []string{"secure.example.com"},
+	}
+	assert.Equal(t, []int{80, 443, 8080, 8443, 0, 0, 0, 0}, lbc.httpRulePorts([]string{"pt.example.com"}))
+}
+
+func TestGroupHTTPServices(t *testing.T) {
+	a := &Service{Name: "a", Port: "80"}
+	admin := &Service{Name: "admin", Port: "8080"}
+	b := &Service{Name: "b", Port: "80"}
+
+	frontends := groupHTTPServices([]*Service{a, admin, b}, "http-frontend", "80")
+	assert.Equal(t, []*HTTPFrontend{
+		{Name: "http-frontend", Port: "80", Services: []*Service{a, b}},
+		{Name: "http-frontend-8080", Port: "8080", Services: []*Service{admin}},
+	}, frontends)
+	assert.Equal(t, frontends[1], httpFrontend(frontends, "8080"))
+	assert.Nil(t, httpFrontend(frontends, "443"))
+}
+
+func TestRulePaths(t *testing.T) {
+	rule := aci.ExtendedIngressRule{
+		Host: "shop.example.com",
+		ExtendedIngressRuleValue: aci.ExtendedIngressRuleValue{
+			HTTP: &aci.HTTPExtendedIngressRuleValue{
+				Paths: []aci.HTTPExtendedIngressPath{
+					{Path: "/cart", Backend: aci.ExtendedIngressBackend{ServiceName: "cart"}},
+				},
+			},
+		},
+	}
+	assert.Equal(t, rule.HTTP.Paths, rulePaths(rule))
+
+	rule.Backend = &aci.ExtendedIngressBackend{ServiceName: "shop-404"}
+	paths := rulePaths(rule)
+	assert.Len(t, paths, 2)
+	assert.Equal(t, aci.HTTPExtendedIngressPath{Backend: aci.ExtendedIngressBackend{ServiceName: "shop-404"}}, paths[1])
+	assert.Len(t, rule.HTTP.Paths, 1)
+}
+
+func TestParseNoRoute(t *testing.T) {
+	lbc := &EngressController{Config: &aci.Ingress{}}
+	assert.Nil(t, lbc.parseNoRoute(0, ""))
+	assert.Equal(t, &NoRoute{Status: 403}, lbc.parseNoRoute(403, ""))
+	assert.Equal(t, &NoRoute{Status: 503}, lbc.parseNoRoute(404, ""))
+	assert.Equal(t, 403, annotation{NoRouteStatus: "403"}.NoRouteStatus())
+
+	lbc.Config.Spec.Backend = &aci.ExtendedIngressBackend{ServiceName: "default"}
+	assert.Nil(t, lbc.parseNoRoute(403, ""))
+}
+
+func TestBackendEndpoints(t *testing.T) {
+	lbc := &EngressController{
+		KubeClient: fake.NewSimpleClientset(
+			&kapi.Service{
+				ObjectMeta: kapi.ObjectMeta{Name: "kube-dns", Namespace: "kube-system"},
+				Spec:       kapi.ServiceSpec{ClusterIP: "10.0.0.10"},
+			},
+			&kapi.Service{
+				ObjectMeta: kapi.ObjectMeta{Name: "legacy", Namespace: "default"},
+				Spec: kapi.ServiceSpec{
+					Type:         kapi.ServiceTypeExternalName,
+					ExternalName: "legacy.example.com",
+					Ports:        []kapi.ServicePort{{Port: 80, TargetPort: intstr.FromInt(8080)}},
+				},
+			},
+		),
+		Config: &aci.Ingress{
+			ObjectMeta: kapi.ObjectMeta{
+				Name:      "foo",
+				Namespace: "default",
+			},
+		},
+		Parsed: &HAProxyOptions{},
+	}
+
+	eps, err := lbc.backendEndpoints([]string{"10.1.0.5:5432", "db.example.com:5432", "bad", "db.example.com:http"}, "", intstr.IntOrString{}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []*Endpoint{
+		{Name: "server-10.1.0.5:5432", IP: "10.1.0.5", Port: "5432"},
+		{Name: "server-db.example.com:5432", IP: "db.example.com", Port: "5432", Resolve: true},
+	}, eps)
+	assert.Equal(t, []string{"10.0.0.10:53"}, lbc.Parsed.Nameservers)
+
+	eps, err = lbc.backendEndpoints(nil, "legacy", intstr.FromInt(80), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []*Endpoint{{Name: "server-legacy.example.com", IP: "legacy.example.com", Port: "8080", Resolve: true}}, eps)
+
+	eps, err = lbc.backendEndpoints(nil, "legacy", intstr.FromInt(443), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "443", eps[0].Port)
+}
+
+func TestSplitWeights(t *testing.T) {
+	stable := make([]*Endpoint, 0)
+	for i := 0; i < 10; i++ {
+		stable = append(stable, &Endpoint{Name: "stable"})
+	}
+	canary := []*Endpoint{{Name: "canary"}}
+
+	eps := splitWeights([][]*Endpoint{stable, canary}, []int{95, 5})
+	assert.Len(t, eps, 11)
+	assert.Equal(t, 256, eps[0].Weight)
+	assert.Equal(t, 135, eps[10].Weight)
+
+	// weights of pods split the share of their service
+	a := []*Endpoint{{Name: "a1", Weight: 3}, {Name: "a2"}}
+	b := []*Endpoint{{Name: "b1"}}
+	eps = splitWeights([][]*Endpoint{a, b}, []int{50, 50})
+	assert.Equal(t, []int{192, 64, 256}, []int{eps[0].Weight, eps[1].Weight, eps[2].Weight})
+
+	assert.Empty(t, splitWeights(nil, nil))
+}
+
+func TestParseRequestMatches(t *testing.T) {
+	matches, err := parseRequestMatches(aci.HTTPExtendedIngressPath{
+		Headers: []aci.RequestMatch{
+			{Name: "X-Canary", Value: "1"},
+			{Name: "User-Agent", Value: "Mobile Safari", Type: aci.RequestMatchRegex},
+		},
+		Cookies:     []aci.RequestMatch{{Name: "canary"}},
+		QueryParams: []aci.RequestMatch{{Name: "debug", Value: "#on"}},
+		Methods:     []string{"get", "HEAD"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"req.hdr(X-Canary) -m str 1",
+		`req.hdr(User-Agent) -m reg Mobile\ Safari`,
+		"req.cook(canary) -m found",
+		`urlp(debug) -m str \#on`,
+		"method GET HEAD",
+	}, matches)
+
+	matches, err = parseRequestMatches(aci.HTTPExtendedIngressPath{})
+	assert.Nil(t, err)
+	assert.Empty(t, matches)
+
+	for _, path := range []aci.HTTPExtendedIngressPath{
+		{Headers: []aci.RequestMatch{{Name: "X Canary", Value: "1"}}},
+		{Cookies: []aci.RequestMatch{{Name: "canary", Value: "(1", Type: aci.RequestMatchRegex}}},
+		{QueryParams: []aci.RequestMatch{{Name: "debug", Value: "1", Type: "Prefix"}}},
+		{Methods: []string{"GET POST"}},
+	} {
+		_, err := parseRequestMatches(path)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestServiceName(t *testing.T) {
+	dataTable := map[string][]string{
+		"shop.example.com-80-api_v1-cart:80": {"shop.example.com", "80", "/api/v1/", "cart:80"},
+		"wildcard.example.com-8443-web:http": {"*.example.com", "8443", "/", "web:http"},
+		"80-api_v_0-9-web:80":                {"", "80", "^/api/v[0-9]+$", "web:80"},
+		"db.example.com-5432-endpoints":      {"db.example.com", "5432", "", "endpoints"},
+	}
+	for exp, v := range dataTable {
+		assert.Equal(t, exp, serviceName(v[0], v[1], v[2], v[3]))
+	}
+
+	assert.Equal(t, "cart:80", pathServiceName(aci.HTTPExtendedIngressPath{
+		Backend: aci.ExtendedIngressBackend{ServiceName: "cart", ServicePort: intstr.FromInt(80)},
+	}))
+	assert.Equal(t, "endpoints", pathServiceName(aci.HTTPExtendedIngressPath{
+		Backend: aci.ExtendedIngressBackend{Endpoints: []string{"10.0.0.1:80"}},
+	}))
+	assert.Equal(t, "stable_canary", pathServiceName(aci.HTTPExtendedIngressPath{
+		Backends: []aci.WeightedBackend{{ServiceName: "stable", Weight: 90}, {ServiceName: "canary", Weight: 10}},
+	}))
+}
+
+func TestUniqueServiceNames(t *testing.T) {
+	http := []*Service{{Name: "a-80", index: "0.0"}, {Name: "a-80", index: "2.1"}, {Name: "a-80-2", index: "1.0"}, {Name: "b-80", index: "3.0"}}
+	https := []*Service{{Name: "a-80", index: "4.0"}}
+	tcp := []*TCPService{{Name: "a-80", index: "5.0"}, {Name: "a-80", index: "5"}}
+	uniqueServiceNames(http, https, tcp)
+
+	// only the services sharing a name are renamed
+	assert.Equal(t, []string{"a-80-0.0", "a-80-2.1", "a-80-2", "b-80"}, []string{http[0].Name, http[1].Name, http[2].Name, http[3].Name})
+	assert.Equal(t, "a-80", https[0].Name)
+	assert.Equal(t, []string{"a-80-5.0", "a-80-5"}, []string{tcp[0].Name, tcp[1].Name})
+}
+
+func TestEndpointNames(t *testing.T) {
+	assert.Equal(t, "web-1", endpointName(kapi.EndpointAddress{IP: "10.0.0.1", TargetRef: &kapi.ObjectReference{Kind: "Pod", Name: "web-1"}}))
+	assert.Equal(t, "10.0.0.2", endpointName(kapi.EndpointAddress{IP: "10.0.0.2", TargetRef: &kapi.ObjectReference{Kind: "Node", Name: "node-1"}}))
+	assert.Equal(t, "10.0.0.3", endpointName(kapi.EndpointAddress{IP: "10.0.0.3"}))
+
+	eps := []*Endpoint{
+		{Name: "server-web-2", IP: "10.0.0.2", Port: "80"},
+		{Name: "server-web-1", IP: "10.0.0.1", Port: "8080"},
+		{Name: "server-web-1", IP: "10.0.0.1", Port: "80"},
+		{Name: "server-10.0.0.3", IP: "10.0.0.3", Port: "80"},
+		{Name: "server-10.0.0.3", IP: "10.0.0.3", Port: "80"},
+	}
+	sort.Sort(endpointsByName(eps))
+	uniqueEndpointNames(eps)
+	names := make([]string, 0)
+	for _, ep := range eps {
+		names = append(names, ep.Name)
+	}
+	assert.Equal(t, []string{"server-10.0.0.3:80-0", "server-10.0.0.3:80-1", "server-web-1:80", "server-web-1:8080", "server-web-2"}, names)
+}
+
+func TestParseSpecReproducible(t *testing.T) {
+	service := &kapi.Service{
+		ObjectMeta: kapi.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: kapi.ServiceSpec{
+			Ports: []kapi.ServicePort{{Port: 80, TargetPort: intstr.FromInt(8080)}},
+		},
+	}
+	address := func(pod, ip string) kapi.EndpointAddress {
+		return kapi.EndpointAddress{IP: ip, TargetRef: &kapi.ObjectReference{Kind: "Pod", Namespace: "default", Name: pod}}
+	}
+	addresses := []kapi.EndpointAddress{address("web-a", "10.2.0.7"), address("web-b", "10.2.0.3"), address("web-c", "10.2.0.5")}
+	web := aci.ExtendedIngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(80)}
+	ing := &aci.Ingress{
+		ObjectMeta: kapi.ObjectMeta{Name: "foo", Namespace: "default"},
+		Spec: aci.ExtendedIngressSpec{
+			Rules: []aci.ExtendedIngressRule{
+				{
+					Host: "shop.example.com",
+					ExtendedIngressRuleValue: aci.ExtendedIngressRuleValue{
+						HTTP: &aci.HTTPExtendedIngressRuleValue{
+							Paths: []aci.HTTPExtendedIngressPath{
+								{Path: "/api", Backend: web},
+								{Path: "/api", Cookies: []aci.RequestMatch{{Name: "canary"}}, Backend: web},
+								{Path: "/", Backend: web},
+							},
+						},
+						TCP: []aci.TCPExtendedIngressRuleValue{
+							{Port: intstr.FromInt(5432), Backend: aci.IngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(80)}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	render := func(addresses []kapi.EndpointAddress) string {
+		endpoints := cache.NewStore(cache.MetaNamespaceKeyFunc)
+		endpoints.Add(&kapi.Endpoints{
+			ObjectMeta: kapi.ObjectMeta{Name: "web", Namespace: "default"},
+			Subsets: []kapi.EndpointSubset{{
+				Addresses: addresses,
+				Ports:     []kapi.EndpointPort{{Port: 8080}},
+			}},
+		})
+		lbc := &EngressController{
+			KubeClient:    fake.NewSimpleClientset(service),
+			Config:        ing,
+			Options:       &KubeOptions{},
+			Parsed:        &HAProxyOptions{},
+			EndpointStore: cache.StoreToEndpointsLister{Store: endpoints},
+			Storage: &stash.Storage{
+				PodStore: cache.StoreToPodLister{Indexer: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})},
+			},
+		}
+		assert.Nil(t, lbc.parse())
+		assert.Nil(t, lbc.generateTemplate())
+		return lbc.Options.ConfigData
+	}
+
+	first := render(addresses)
+	assert.Contains(t, first, "backend http-shop.example.com-80-api-web:80-0.0")
+	assert.Contains(t, first, "server server-web-a 10.2.0.7:8080")
+	second := render([]kapi.EndpointAddress{addresses[2], addresses[0], addresses[1]})
+	assert.Equal(t, first, second)
+}
+
+func TestStatsAcceptProxy(t *testing.T) {
+	lbc := &EngressController{
+		Options: &KubeOptions{},
+		Parsed:  &HAProxyOptions{Stats: true, AcceptProxy: true},
+	}
+	assert.Nil(t, lbc.generateTemplate())
+	// the ELB sends the PROXY protocol header to the stats port too
+	assert.Contains(t, lbc.Options.ConfigData, "bind *:1936 accept-proxy")
+
+	lbc.Parsed.AcceptProxy = false
+	assert.Nil(t, lbc.generateTemplate())
+	assert.NotContains(t, lbc.Options.ConfigData, "accept-proxy")
+}
+
+func TestRateLimitTrackedOnce(t *testing.T) {
+	secure := &TCPService{Name: "secure", Port: "443", Host: "secure.example.com"}
+	postgres := &TCPService{Name: "postgres", Port: "5432"}
+	frontends := groupTCPServices([]*TCPService{secure, postgres})
+	lbc := &EngressController{
+		Options: &KubeOptions{},
+		Parsed: &HAProxyOptions{
+			HttpsPassthrough: passthroughHTTPS(frontends),
+			TLS:              &TLSOptions{},
+			HttpsFrontends:   []*HTTPFrontend{{Name: "https-frontend", Port: "443"}},
+			TCPFrontends:     frontends,
+			RateLimit:        &RateLimit{Connections: 10},
+		},
+	}
+	assert.Nil(t, lbc.generateTemplate())
+
+	sections := make(map[string]string)
+	for _, section := range strings.Split(lbc.Options.ConfigData, "\nfrontend ")[1:] {
+		sections[strings.Fields(section)[0]] = section
+	}
+	// terminated connections are tracked by the https-frontend only,
+	// passed through ones by the tcp frontend only
+	assert.Equal(t, 1, strings.Count(sections["https-frontend"], "track-sc0"))
+	assert.Equal(t, 1, strings.Count(sections["tcp-frontend-key-443"], "track-sc0"))
+	assert.Contains(t, sections["tcp-frontend-key-443"], "tcp-request content track-sc0 src table rate-limit if ___sni_secure")
+	assert.NotContains(t, sections["tcp-frontend-key-443"], "tcp-request connection track-sc0")
+	assert.Contains(t, sections["tcp-frontend-key-5432"], "tcp-request connection track-sc0 src table rate-limit")
+}
+
+func TestTCPSourceRanges(t *testing.T) {
+	ranges := []*SourceRange{parseSourceRange("", []string{"10.0.0.0/8"}, nil)}
+	single := &TCPService{Name: "single", Port: "5432", SourceRanges: ranges}
+	kafka := &TCPService{Name: "kafka", Port: "8443", Host: "kafka.example.com", SourceRanges: ranges}
+	only := &TCPService{Name: "only", Port: "9000", Host: "only.example.com", SourceRanges: ranges}
+	secure := &TCPService{Name: "secure", Port: "443", Host: "secure.example.com", SourceRanges: ranges}
+	fallback := &TCPService{Name: "fallback", Port: "8443"}
+
+	frontends := groupTCPServices([]*TCPService{single, kafka, fallback, only, secure})
+	assert.Equal(t, ranges, frontends[0].SourceRanges)
+	// hosts sharing a port are checked by server name
+	assert.Nil(t, frontends[1].SourceRanges)
+	assert.Equal(t, ranges, frontends[2].SourceRanges)
+	assert.Equal(t, ranges, frontends[3].SourceRanges)
+
+	// other hosts on 443 are passed to the https-frontend
+	assert.True(t, passthroughHTTPS(frontends))
+	assert.Nil(t, frontends[3].SourceRanges)
+}
+
+func TestIgnoredTLSProfileHosts(t *testing.T) {
+	profiles := []*TLSProfile{{Name: "https-tls-1", Hosts: []string{"old.example.com", "legacy.example.com"}}}
+	frontends := []*HTTPFrontend{
+		{Port: "443", Services: []*Service{{Host: "old.example.com"}, {Host: "legacy.example.com"}}},
+		{Port: "8443", Services: []*Service{{Host: "legacy.example.com", AclMatch: "/a"}, {Host: "legacy.example.com", AclMatch: "/b"}, {Host: "new.example.com"}}},
+	}
+	assert.Equal(t, []string{"legacy.example.com"}, ignoredTLSProfileHosts(profiles, frontends))
+	assert.Empty(t, ignoredTLSProfileHosts(profiles, frontends[:1]))
+}
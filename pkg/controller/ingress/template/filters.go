@@ -1,6 +1,7 @@
 package template
 
 import (
+	"regexp"
 	"strings"
 
 	"github.com/flosch/pongo2"
@@ -10,6 +11,8 @@ import (
 func init() {
 	pongo2.RegisterFilter("header_name", HeaderNameFilter)
 	pongo2.RegisterFilter("host_name", HostNameFilter)
+	pongo2.RegisterFilter("path_acl", PathACLFilter)
+	pongo2.RegisterFilter("sni_acl", SNIACLFilter)
 }
 
 func HeaderNameFilter(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
@@ -37,3 +40,38 @@ func HostNameFilter(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pong
 	}
 	return pongo2.AsValue("hdr(host) -i " + v), nil
 }
+
+// SNIACLFilter renders the acl matching a TLS server name against a host.
+// The filter parameter is the sample fetch of the server name.
+func SNIACLFilter(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	v := strings.TrimSpace(in.String())
+	if v == "" {
+		return pongo2.AsValue(v), nil
+	}
+	if strings.HasPrefix(v, "*") {
+		return pongo2.AsValue(param.String() + " -m end -i " + v[1:]), nil
+	}
+	return pongo2.AsValue(param.String() + " -i " + v), nil
+}
+
+// PathACLFilter renders the acl criterion matching a request path. The
+// filter parameter is the path match mode, defaults to prefix match.
+func PathACLFilter(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	v := strings.TrimSpace(in.String())
+	if v == "" {
+		return pongo2.AsValue(v), nil
+	}
+	switch param.String() {
+	case "Exact":
+		return pongo2.AsValue("path " + v), nil
+	case "Regex":
+		return pongo2.AsValue("path_reg " + v), nil
+	case "SegmentPrefix":
+		v = strings.TrimRight(v, "/")
+		if v == "" {
+			return pongo2.AsValue("path_beg /"), nil
+		}
+		return pongo2.AsValue("path_reg ^" + regexp.QuoteMeta(v) + "(/|$)"), nil
+	}
+	return pongo2.AsValue("path_beg " + v), nil
+}
@@ -57,3 +57,53 @@ func render(ctx *pongo2.Context, temp string) (string, error) {
 	}
 	return out, nil
 }
+
+func TestPathACLFilter(t *testing.T) {
+	temp := `
+{{ val|path_acl:"" }}
+{{ val|path_acl:"Prefix" }}
+{{ val|path_acl:"Exact" }}
+{{ val|path_acl:"SegmentPrefix" }}
+{{ val2|path_acl:"SegmentPrefix" }}
+{{ val3|path_acl:"SegmentPrefix" }}
+{{ val4|path_acl:"Regex" }}
+	`
+	ctx := &pongo2.Context{
+		"val":  "/api",
+		"val2": "/api.v1/",
+		"val3": "/",
+		"val4": "^/api/v[0-9]+$",
+	}
+	res, _ := render(ctx, temp)
+	exp := `
+path_beg /api
+path_beg /api
+path /api
+path_reg ^/api(/|$)
+path_reg ^/api\.v1(/|$)
+path_beg /
+path_reg ^/api/v[0-9]+$
+	`
+	assert.Equal(t, res, exp)
+
+	// regular expressions must not be HTML escaped
+	res, _ = render(&pongo2.Context{"val": `^/a<b>&'c'$`}, `{{ val|path_acl:"Regex"|safe }}`)
+	assert.Equal(t, `path_reg ^/a<b>&'c'$`, res)
+}
+
+func TestSNIACLFilter(t *testing.T) {
+	temp := `
+{{ val|sni_acl:"req.ssl_sni" }}
+{{ val2|sni_acl:"ssl_fc_sni" }}
+	`
+	ctx := &pongo2.Context{
+		"val":  "db.appscode.com",
+		"val2": "*.appscode.com",
+	}
+	res, _ := render(ctx, temp)
+	exp := `
+req.ssl_sni -i db.appscode.com
+ssl_fc_sni -m end -i .appscode.com
+	`
+	assert.Equal(t, res, exp)
+}
@@ -8,58 +8,79 @@ global
     stats socket /tmp/haproxy
     server-state-file global
     server-state-base /var/state/haproxy/
-    maxconn 4000
+    maxconn {{ MaxConn|integer }}
     # log using a syslog socket
     log /dev/log local0 info
     log /dev/log local0 notice
     {% if SSLCert %}
-    tune.ssl.default-dh-param 2048
-    ssl-default-bind-ciphers ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-AES256-GCM-SHA384:DHE-RSA-AES128-GCM-SHA256:DHE-DSS-AES128-GCM-SHA256:kEDH+AESGCM:ECDHE-RSA-AES128-SHA256:ECDHE-ECDSA-AES128-SHA256:ECDHE-RSA-AES128-SHA:ECDHE-ECDSA-AES128-SHA:ECDHE-RSA-AES256-SHA384:ECDHE-ECDSA-AES256-SHA384:ECDHE-RSA-AES256-SHA:ECDHE-ECDSA-AES256-SHA:DHE-RSA-AES128-SHA256:DHE-RSA-AES128-SHA:DHE-DSS-AES128-SHA256:DHE-RSA-AES256-SHA256:DHE-DSS-AES256-SHA:DHE-RSA-AES256-SHA:!aNULL:!eNULL:!EXPORT:!DES:!RC4:!3DES:!MD5:!PSK
+    tune.ssl.default-dh-param {{ TLS.DHParamSize|integer }}
+    ssl-default-bind-ciphers {{ TLS.Ciphers }}
     {% endif %}
 
 defaults
     log global
 
-    option http-server-close
+    option {{ ConnectionMode }}
+    {% if HTTPReuse %}http-reuse {{ HTTPReuse }}{% endif %}
 
     # Disable logging of null connections (haproxy connections like checks).
     # This avoids excessive logs from haproxy internals.
     option dontlognull
 
     # Maximum time to wait for a connection attempt to a server to succeed.
-    timeout connect         50000
+    timeout connect         {{ TimeoutConnect }}
 
     # Maximum inactivity time on the client side.
     # Applies when the client is expected to acknowledge or send data.
-    timeout client          50000
+    timeout client          {{ TimeoutClient }}
 
     # Inactivity timeout on the client side for half-closed connections.
     # Applies when the client is expected to acknowledge or send data
     # while one direction is already shut down.
-    timeout client-fin      50000
+    timeout client-fin      {{ TimeoutClientFin }}
 
     # Maximum inactivity time on the server side.
-    timeout server          50000
+    timeout server          {{ TimeoutServer }}
 
     # timeout to use with WebSocket and CONNECT
-    timeout tunnel          50000
+    timeout tunnel          {{ TimeoutTunnel }}
 
     # default traffic mode is http
     # mode is overwritten in case of tcp services
     mode http
 
-    # errorloc 400 https://appscode.com/errors/400
-    # errorloc 403 https://appscode.com/errors/403
-    # errorloc 408 https://appscode.com/errors/408
-    # errorloc 500 https://appscode.com/errors/500
-    # errorloc 502 https://appscode.com/errors/502
-    # errorloc 503 https://appscode.com/errors/503
-    # errorloc 504 https://appscode.com/errors/504
+    {% for f in ErrorFiles %}
+    errorfile {{ f.Code }} {{ f.Path }}
+    {% endfor %}
+
+{% if Nameservers %}
+# resolves the host names of servers at runtime
+resolvers cluster-dns
+    {% for ns in Nameservers %}
+    nameserver dns{{ forloop.Counter }} {{ ns }}
+    {% endfor %}
+    resolve_retries 3
+    timeout retry 1s
+    hold valid 10s
+{% endif %}
 
 
+{% for list in UserLists %}
+userlist {{ list.Name }}
+    {% for user in list.Users %}
+    user {{ user.Name }} password {{ user.Password }}
+    {% endfor %}
+{% endfor %}
+
+{% if RateLimit %}
+# rate limit counters per client ip of all frontends
+backend rate-limit
+    stick-table type ip size 100k expire 30s store conn_cur,conn_rate(1s),http_req_rate(1s)
+{% endif %}
+
 {% if Stats %}
 listen stats
-    bind *:1936
+    bind *:1936{% if AcceptProxy %} accept-proxy{% endif %}
     mode http
     stats enable
     stats realm Haproxy\ Statistics
@@ -70,7 +91,19 @@ listen stats
 {% if DefaultBackend %}
 # default backend
 backend default-backend
-    {% if Sticky %}cookie SERVERID insert indirect nocache{% endif %}
+    {% if DefaultBackend.Balance %}balance {{ DefaultBackend.Balance }}{% endif %}
+    {% if DefaultBackend.HashType %}hash-type {{ DefaultBackend.HashType }}{% endif %}
+    {% if DefaultBackend.SessionAffinity.CookieName %}cookie {{ DefaultBackend.SessionAffinity.CookieName }} {{ DefaultBackend.SessionAffinity.CookieMode }}{% if DefaultBackend.SessionAffinity.CookieMode == "insert" %} indirect nocache{% if DefaultBackend.SessionAffinity.CookieMaxLife %} maxlife {{ DefaultBackend.SessionAffinity.CookieMaxLife }}{% endif %}{% endif %}{% endif %}
+    {% if DefaultBackend.SessionAffinity.StickOn %}
+    stick-table type {{ DefaultBackend.SessionAffinity.StickTableType }} size 100k expire 30m
+    stick on {{ DefaultBackend.SessionAffinity.StickOn }}
+    {% endif %}
+
+    {% if DefaultBackend.RateLimit %}
+    http-request track-sc1 src table default-backend-rate-limit
+    {% if DefaultBackend.RateLimit.RequestsPerSecond %}http-request {% if DefaultBackend.RateLimit.Tarpit %}tarpit{% else %}deny deny_status 429{% endif %} if { sc1_http_req_rate gt {{ DefaultBackend.RateLimit.RequestsPerSecond|integer }} }{% endif %}
+    {% if DefaultBackend.RateLimit.Connections %}http-request {% if DefaultBackend.RateLimit.Tarpit %}tarpit{% else %}deny deny_status 429{% endif %} if { sc1_conn_cur gt {{ DefaultBackend.RateLimit.Connections|integer }} }{% endif %}
+    {% endif %}
 
     {% for rule in DefaultBackend.BackendRules %}
     {{ rule }}
@@ -85,36 +118,126 @@ backend default-backend
     http-request add-header {{ rule }} unless ___header_x_{{ forloop.Counter }}_exists
     {% endfor %}
 
+    {% for f in DefaultBackend.ErrorFiles %}
+    errorfile {{ f.Code }} {{ f.Path }}
+    {% endfor %}
+
+    {% if DefaultBackend.HealthCheck.Path %}
+    option httpchk {{ DefaultBackend.HealthCheck.Method }} {{ DefaultBackend.HealthCheck.Path }}
+    {% if DefaultBackend.HealthCheck.ExpectStatus %}http-check expect status {{ DefaultBackend.HealthCheck.ExpectStatus|integer }}{% endif %}
+    {% endif %}
+
     {% for e in DefaultBackend.Endpoints %}
-    server {{ e.Name }} {{ e.IP }}:{{ e.Port }} {% if e.Weight %}weight {{ e.Weight|integer }} {% endif %} {% if Sticky %}cookie {{ e.Name }} {% endif %}
+    server {{ e.Name }} {{ e.IP }}:{{ e.Port }} {% if e.Weight %}weight {{ e.Weight|integer }} {% endif %} {% if DefaultBackend.SessionAffinity.CookieName %}cookie {{ e.Name }} {% endif %} {% if DefaultBackend.HealthCheck %}check {% if DefaultBackend.HealthCheck.SSL and not DefaultBackend.UpstreamTLS %}check-ssl verify none {% endif %}inter {{ DefaultBackend.HealthCheck.Interval }} rise {{ DefaultBackend.HealthCheck.Rise|integer }} fall {{ DefaultBackend.HealthCheck.Fall|integer }}{% endif %}{% if DefaultBackend.UpstreamTLS %} ssl {% if DefaultBackend.UpstreamTLS.CAFile %}verify required ca-file {{ DefaultBackend.UpstreamTLS.CAFile }} {% if DefaultBackend.UpstreamTLS.VerifyHost %}verifyhost {{ DefaultBackend.UpstreamTLS.VerifyHost }} {% endif %}{% else %}verify none {% endif %}{% if DefaultBackend.UpstreamTLS.CrtFile %}crt {{ DefaultBackend.UpstreamTLS.CrtFile }} {% endif %}{% if DefaultBackend.UpstreamTLS.SNI %}sni str({{ DefaultBackend.UpstreamTLS.SNI }}){% endif %}{% endif %}{% if DefaultBackend.H2 %} {% if DefaultBackend.UpstreamTLS %}alpn h2{% else %}proto h2{% endif %}{% endif %}{% if DefaultBackend.SendProxy %} {{ DefaultBackend.SendProxy }}{% if DefaultBackend.HealthCheck %} check-send-proxy{% endif %}{% endif %}{% if e.Resolve %} resolvers cluster-dns resolve-prefer ipv4 init-addr last,libc,none{% endif %}
     {% endfor %}
+
+{% if DefaultBackend.RateLimit %}
+backend default-backend-rate-limit
+    stick-table type ip size 100k expire 30s store conn_cur,http_req_rate(1s)
+{% endif %}
 {% endif %}
 
-{% if HttpsService %}
+{% for fe in HttpsFrontends %}
 # https service
-frontend https-frontend
-    bind *:443 ssl no-sslv3 no-tlsv10 no-tls-tickets crt /etc/ssl/private/haproxy/ alpn http/1.1
-    # Mark all cookies as secure
-    rsprep ^Set-Cookie:\ (.*) Set-Cookie:\ \1;\ Secure
-    # Add the HSTS header with a 6 month max-age
-    rspadd  Strict-Transport-Security:\ max-age=15768000
+frontend {{ fe.Name }}
+    {% if HttpsPassthrough and fe.Port == "443" %}
+    # reached through tcp-frontend-key-443, which routes hosts by their TLS server name
+    bind abns@https-frontend accept-proxy ssl {{ TLS.BindOptions }} crt /etc/ssl/private/haproxy/ alpn {{ TLS.ALPN }} {% if fe.ClientCA %}ca-file {{ fe.ClientCA.CAFile }} {% if fe.ClientCA.CRLFile %}crl-file {{ fe.ClientCA.CRLFile }} {% endif %}verify optional ca-ignore-err all crt-ignore-err all{% endif %}
+    {% for p in HttpsTLSProfiles %}
+    bind abns@{{ p.Name }} accept-proxy ssl {{ p.TLS.BindOptions }} ciphers {{ p.TLS.Ciphers }} crt /etc/ssl/private/haproxy/{{ p.SecretName }}.pem alpn {{ p.TLS.ALPN }} {% if p.ClientCA %}ca-file {{ p.ClientCA.CAFile }} {% if p.ClientCA.CRLFile %}crl-file {{ p.ClientCA.CRLFile }} {% endif %}verify optional ca-ignore-err all crt-ignore-err all{% endif %}
+    {% endfor %}
+    {% else %}
+    bind *:{{ fe.Port }}{% if AcceptProxy %} accept-proxy{% endif %} ssl {{ TLS.BindOptions }} crt /etc/ssl/private/haproxy/ alpn {{ TLS.ALPN }} {% if fe.ClientCA %}ca-file {{ fe.ClientCA.CAFile }} {% if fe.ClientCA.CRLFile %}crl-file {{ fe.ClientCA.CRLFile }} {% endif %}verify optional ca-ignore-err all crt-ignore-err all{% endif %}
+    {% endif %}
+    {% if SecureCookies %}
+    # Mark all cookies as secure, unless they already are
+    rspirep ^Set-Cookie:\ ((?!.*;\ *secure(;|$)).*)$ Set-Cookie:\ \1;\ Secure
+    {% endif %}
+    {% for h in HSTS %}{% for host in h.Hosts %}
+    acl ___hsts_{{ forloop.Parentloop.Counter }} {{ host|sni_acl:"ssl_fc_sni" }}
+    {% endfor %}{% endfor %}
+    {% for h in HSTS %}
+    {% if h.Value and h.Hosts %}
+    http-response set-header Strict-Transport-Security "{{ h.Value }}" if ___hsts_{{ forloop.Counter }}
+    {% elif h.Value %}
+    http-response set-header Strict-Transport-Security "{{ h.Value }}"{% if HSTS|length > 1 %} if{% for o in HSTS %}{% if o.Hosts %} !___hsts_{{ forloop.Counter }}{% endif %}{% endfor %}{% endif %}
+    {% endif %}
+    {% endfor %}
 
     mode http
     option httplog
     option forwardfor
 
-{% for svc in HttpsService %}
+    {% if TrustedProxies %}
+    acl ___trusted_proxy src {{ TrustedProxies|join:" " }}
+    http-request set-src hdr_ip(X-Forwarded-For,-1) if ___trusted_proxy
+    {% endif %}
+    {% for r in SourceRanges %}
+    {% if r.Host %}acl ___src_host_{{ forloop.Counter }} {{ r.Host|host_name }}{% endif %}
+    {% if r.Whitelist %}
+    acl ___src_allow_{{ forloop.Counter }} src {{ r.Whitelist|join:" " }}
+    http-request deny if {% if r.Host %}___src_host_{{ forloop.Counter }} {% endif %}!___src_allow_{{ forloop.Counter }}
+    {% endif %}
+    {% if r.Blacklist %}
+    acl ___src_deny_{{ forloop.Counter }} src {{ r.Blacklist|join:" " }}
+    http-request deny if {% if r.Host %}___src_host_{{ forloop.Counter }} {% endif %}___src_deny_{{ forloop.Counter }}
+    {% endif %}
+    {% endfor %}
+    {% if RateLimit %}
+    http-request track-sc0 src table rate-limit
+    {% if RateLimit.RequestsPerSecond %}http-request {% if RateLimit.Tarpit %}tarpit{% else %}deny deny_status 429{% endif %} if { sc0_http_req_rate gt {{ RateLimit.RequestsPerSecond|integer }} }{% endif %}
+    {% if RateLimit.Connections %}http-request {% if RateLimit.Tarpit %}tarpit{% else %}deny deny_status 429{% endif %} if { sc0_conn_cur gt {{ RateLimit.Connections|integer }} }{% endif %}
+    {% endif %}
+    {% if ClientAuth %}
+    # client certificates are passed to the backends of hosts with client auth only
+    http-request del-header X-SSL-Client-Verify
+    http-request del-header X-SSL-Client-DN
+    http-request del-header X-SSL-Client-SHA1
+    {% endif %}
+    {% for a in ClientAuth %}
+    {% for h in a.Hosts %}
+    acl ___client_auth_{{ forloop.Parentloop.Counter }} {{ h|host_name }}
+    {% endfor %}
+    {% if a.Required %}
+    http-request deny if {% if a.Hosts %}___client_auth_{{ forloop.Counter }} {% endif %}!{ ssl_c_used }
+    http-request deny if {% if a.Hosts %}___client_auth_{{ forloop.Counter }} {% endif %}!{ ssl_c_verify 0 }
+    {% endif %}
+    http-request set-header X-SSL-Client-Verify %[ssl_c_verify] if {% if a.Hosts %}___client_auth_{{ forloop.Counter }} {% endif %}{ ssl_c_used }
+    http-request set-header X-SSL-Client-DN %{+Q}[ssl_c_s_dn] if {% if a.Hosts %}___client_auth_{{ forloop.Counter }} {% endif %}{ ssl_c_used }
+    http-request set-header X-SSL-Client-SHA1 %[ssl_c_sha1,hex] if {% if a.Hosts %}___client_auth_{{ forloop.Counter }} {% endif %}{ ssl_c_used }
+    {% endfor %}
+
+{% for svc in fe.Services %}
     {% set both = 0 %}
-    {% if svc.AclMatch %}acl url_acl_{{ svc.Name }} path_beg {{ svc.AclMatch }} {% set both = both + 1 %}{% endif %}
+    {% if svc.AclMatch %}acl url_acl_{{ svc.Name }} {{ svc.AclMatch|path_acl:svc.PathMatch|safe }} {% set both = both + 1 %}{% endif %}
     {% if svc.Host %}acl host_acl_{{ svc.Name }} {{ svc.Host|host_name }} {% set both = both + 1 %}{% endif %}
-    use_backend https-{{ svc.Name }} {% if both != 0 %}if {% endif %}{% if svc.AclMatch %}url_acl_{{ svc.Name }}{% endif %} {% if svc.Host %}host_acl_{{ svc.Name }}{% endif %}
+    {% for m in svc.Matches %}acl match_acl_{{ svc.Name }}_{{ forloop.Counter }} {{ m|safe }}
+    {% endfor %}
+    use_backend https-{{ svc.Name }} {% if both != 0 or svc.Matches %}if {% endif %}{% if svc.AclMatch %}url_acl_{{ svc.Name }}{% endif %} {% if svc.Host %}host_acl_{{ svc.Name }}{% endif %}{% for m in svc.Matches %} match_acl_{{ svc.Name }}_{{ forloop.Counter }}{% endfor %}
+{% endfor %}
+    {% if DefaultBackend %}default_backend default-backend{% elif NoRoute %}default_backend no-route{% endif %}
 {% endfor %}
-    {% if DefaultBackend %}default_backend default-backend{% endif %}
-{% endif %}
 
 {% for svc in HttpsService %}
 backend https-{{ svc.Name }}
-    {% if Sticky %}cookie SERVERID insert indirect nocache{% endif %}
+    {% if svc.Backends.Balance %}balance {{ svc.Backends.Balance }}{% endif %}
+    {% if svc.Backends.HashType %}hash-type {{ svc.Backends.HashType }}{% endif %}
+    {% if svc.Backends.SessionAffinity.CookieName %}cookie {{ svc.Backends.SessionAffinity.CookieName }} {{ svc.Backends.SessionAffinity.CookieMode }}{% if svc.Backends.SessionAffinity.CookieMode == "insert" %} indirect nocache{% if svc.Backends.SessionAffinity.CookieMaxLife %} maxlife {{ svc.Backends.SessionAffinity.CookieMaxLife }}{% endif %}{% endif %}{% endif %}
+    {% if svc.Backends.SessionAffinity.StickOn %}
+    stick-table type {{ svc.Backends.SessionAffinity.StickTableType }} size 100k expire 30m
+    stick on {{ svc.Backends.SessionAffinity.StickOn }}
+    {% endif %}
+
+    {% if svc.Backends.RateLimit %}
+    http-request track-sc1 src table https-{{ svc.Name }}-rate-limit
+    {% if svc.Backends.RateLimit.RequestsPerSecond %}http-request {% if svc.Backends.RateLimit.Tarpit %}tarpit{% else %}deny deny_status 429{% endif %} if { sc1_http_req_rate gt {{ svc.Backends.RateLimit.RequestsPerSecond|integer }} }{% endif %}
+    {% if svc.Backends.RateLimit.Connections %}http-request {% if svc.Backends.RateLimit.Tarpit %}tarpit{% else %}deny deny_status 429{% endif %} if { sc1_conn_cur gt {{ svc.Backends.RateLimit.Connections|integer }} }{% endif %}
+    {% endif %}
+
+    {% if svc.Backends.BasicAuth %}
+    acl ___auth_ok http_auth({{ svc.Backends.BasicAuth.UserList }})
+    http-request auth realm {{ svc.Backends.BasicAuth.Realm }} unless ___auth_ok
+    {% endif %}
 
     {% for rule in svc.Backends.BackendRules %}
     {{ rule }}
@@ -129,31 +252,92 @@ backend https-{{ svc.Name }}
     http-request add-header {{ rule }} unless ___header_x_{{ forloop.Counter }}_exists
     {% endfor %}
 
+    {% for f in svc.Backends.ErrorFiles %}
+    errorfile {{ f.Code }} {{ f.Path }}
+    {% endfor %}
+
+    {% if svc.Backends.HealthCheck.Path %}
+    option httpchk {{ svc.Backends.HealthCheck.Method }} {{ svc.Backends.HealthCheck.Path }}
+    {% if svc.Backends.HealthCheck.ExpectStatus %}http-check expect status {{ svc.Backends.HealthCheck.ExpectStatus|integer }}{% endif %}
+    {% endif %}
+
     {% for e in svc.Backends.Endpoints %}
-    server {{ e.Name }} {{ e.IP }}:{{ e.Port }} {% if e.Weight %}weight {{ e.Weight|integer }} {% endif %} {% if Sticky %} cookie {{ e.Name }} {% endif %}
+    server {{ e.Name }} {{ e.IP }}:{{ e.Port }} {% if e.Weight %}weight {{ e.Weight|integer }} {% endif %} {% if svc.Backends.SessionAffinity.CookieName %}cookie {{ e.Name }} {% endif %} {% if svc.Backends.HealthCheck %}check {% if svc.Backends.HealthCheck.SSL and not svc.Backends.UpstreamTLS %}check-ssl verify none {% endif %}inter {{ svc.Backends.HealthCheck.Interval }} rise {{ svc.Backends.HealthCheck.Rise|integer }} fall {{ svc.Backends.HealthCheck.Fall|integer }}{% endif %}{% if svc.Backends.UpstreamTLS %} ssl {% if svc.Backends.UpstreamTLS.CAFile %}verify required ca-file {{ svc.Backends.UpstreamTLS.CAFile }} {% if svc.Backends.UpstreamTLS.VerifyHost %}verifyhost {{ svc.Backends.UpstreamTLS.VerifyHost }} {% endif %}{% else %}verify none {% endif %}{% if svc.Backends.UpstreamTLS.CrtFile %}crt {{ svc.Backends.UpstreamTLS.CrtFile }} {% endif %}{% if svc.Backends.UpstreamTLS.SNI %}sni str({{ svc.Backends.UpstreamTLS.SNI }}){% endif %}{% endif %}{% if svc.Backends.H2 %} {% if svc.Backends.UpstreamTLS %}alpn h2{% else %}proto h2{% endif %}{% endif %}{% if svc.Backends.SendProxy %} {{ svc.Backends.SendProxy }}{% if svc.Backends.HealthCheck %} check-send-proxy{% endif %}{% endif %}{% if e.Resolve %} resolvers cluster-dns resolve-prefer ipv4 init-addr last,libc,none{% endif %}
     {% endfor %}
+
+{% if svc.Backends.RateLimit %}
+backend https-{{ svc.Name }}-rate-limit
+    stick-table type ip size 100k expire 30s store conn_cur,http_req_rate(1s)
+{% endif %}
 {% endfor %}
 
-{% if HttpService %}
+{% for fe in HttpFrontends %}
 # http services.
-frontend http-frontend
-    bind *:80
+frontend {{ fe.Name }}
+    bind *:{{ fe.Port }}{% if AcceptProxy %} accept-proxy{% endif %}
     mode http
     option httplog
     option forwardfor
 
-{% for svc in HttpService %}
+    {% if TrustedProxies %}
+    acl ___trusted_proxy src {{ TrustedProxies|join:" " }}
+    http-request set-src hdr_ip(X-Forwarded-For,-1) if ___trusted_proxy
+    {% endif %}
+    {% for r in SourceRanges %}
+    {% if r.Host %}acl ___src_host_{{ forloop.Counter }} {{ r.Host|host_name }}{% endif %}
+    {% if r.Whitelist %}
+    acl ___src_allow_{{ forloop.Counter }} src {{ r.Whitelist|join:" " }}
+    http-request deny if {% if r.Host %}___src_host_{{ forloop.Counter }} {% endif %}!___src_allow_{{ forloop.Counter }}
+    {% endif %}
+    {% if r.Blacklist %}
+    acl ___src_deny_{{ forloop.Counter }} src {{ r.Blacklist|join:" " }}
+    http-request deny if {% if r.Host %}___src_host_{{ forloop.Counter }} {% endif %}___src_deny_{{ forloop.Counter }}
+    {% endif %}
+    {% endfor %}
+    {% if RateLimit %}
+    http-request track-sc0 src table rate-limit
+    {% if RateLimit.RequestsPerSecond %}http-request {% if RateLimit.Tarpit %}tarpit{% else %}deny deny_status 429{% endif %} if { sc0_http_req_rate gt {{ RateLimit.RequestsPerSecond|integer }} }{% endif %}
+    {% if RateLimit.Connections %}http-request {% if RateLimit.Tarpit %}tarpit{% else %}deny deny_status 429{% endif %} if { sc0_conn_cur gt {{ RateLimit.Connections|integer }} }{% endif %}
+    {% endif %}
+
+    {% if fe.Port == "80" %}
+    {% for host in SSLRedirectHosts %}
+    acl ssl_redirect_host {{ host|host_name }}
+    {% endfor %}
+    {% if SSLRedirectHosts %}redirect scheme https code {{ SSLRedirectCode|integer }} if ssl_redirect_host{% endif %}
+    {% endif %}
+
+{% for svc in fe.Services %}
     {% set both = 0 %}
-    {% if svc.AclMatch %}acl url_acl_{{ svc.Name }} path_beg {{ svc.AclMatch }} {% set both = both + 1 %}{% endif %}
+    {% if svc.AclMatch %}acl url_acl_{{ svc.Name }} {{ svc.AclMatch|path_acl:svc.PathMatch|safe }} {% set both = both + 1 %}{% endif %}
     {% if svc.Host %}acl host_acl_{{ svc.Name }} {{ svc.Host|host_name }} {% set both = both + 1 %}{% endif %}
-    use_backend http-{{ svc.Name }} {% if both != 0 %}if {% endif %}{% if svc.AclMatch %}url_acl_{{ svc.Name }}{% endif %} {% if svc.Host %}host_acl_{{ svc.Name }}{% endif %}
+    {% for m in svc.Matches %}acl match_acl_{{ svc.Name }}_{{ forloop.Counter }} {{ m|safe }}
+    {% endfor %}
+    use_backend http-{{ svc.Name }} {% if both != 0 or svc.Matches %}if {% endif %}{% if svc.AclMatch %}url_acl_{{ svc.Name }}{% endif %} {% if svc.Host %}host_acl_{{ svc.Name }}{% endif %}{% for m in svc.Matches %} match_acl_{{ svc.Name }}_{{ forloop.Counter }}{% endfor %}
+{% endfor %}
+    {% if DefaultBackend %}default_backend default-backend{% elif NoRoute %}default_backend no-route{% endif %}
 {% endfor %}
-    {% if DefaultBackend %}default_backend default-backend{% endif %}
-{% endif %}
 
 {% for svc in HttpService %}
 backend http-{{ svc.Name }}
-    {% if Sticky %}cookie SERVERID insert indirect nocache{% endif %}
+    {% if svc.Backends.Balance %}balance {{ svc.Backends.Balance }}{% endif %}
+    {% if svc.Backends.HashType %}hash-type {{ svc.Backends.HashType }}{% endif %}
+    {% if svc.Backends.SessionAffinity.CookieName %}cookie {{ svc.Backends.SessionAffinity.CookieName }} {{ svc.Backends.SessionAffinity.CookieMode }}{% if svc.Backends.SessionAffinity.CookieMode == "insert" %} indirect nocache{% if svc.Backends.SessionAffinity.CookieMaxLife %} maxlife {{ svc.Backends.SessionAffinity.CookieMaxLife }}{% endif %}{% endif %}{% endif %}
+    {% if svc.Backends.SessionAffinity.StickOn %}
+    stick-table type {{ svc.Backends.SessionAffinity.StickTableType }} size 100k expire 30m
+    stick on {{ svc.Backends.SessionAffinity.StickOn }}
+    {% endif %}
+
+    {% if svc.Backends.RateLimit %}
+    http-request track-sc1 src table http-{{ svc.Name }}-rate-limit
+    {% if svc.Backends.RateLimit.RequestsPerSecond %}http-request {% if svc.Backends.RateLimit.Tarpit %}tarpit{% else %}deny deny_status 429{% endif %} if { sc1_http_req_rate gt {{ svc.Backends.RateLimit.RequestsPerSecond|integer }} }{% endif %}
+    {% if svc.Backends.RateLimit.Connections %}http-request {% if svc.Backends.RateLimit.Tarpit %}tarpit{% else %}deny deny_status 429{% endif %} if { sc1_conn_cur gt {{ svc.Backends.RateLimit.Connections|integer }} }{% endif %}
+    {% endif %}
+
+    {% if svc.Backends.BasicAuth %}
+    acl ___auth_ok http_auth({{ svc.Backends.BasicAuth.UserList }})
+    http-request auth realm {{ svc.Backends.BasicAuth.Realm }} unless ___auth_ok
+    {% endif %}
 
     {% for rule in svc.Backends.BackendRules %}
     {{ rule }}
@@ -168,45 +352,184 @@ backend http-{{ svc.Name }}
     http-request add-header {{ rule }} unless ___header_x_{{ forloop.Counter }}_exists
     {% endfor %}
 
+    {% for f in svc.Backends.ErrorFiles %}
+    errorfile {{ f.Code }} {{ f.Path }}
+    {% endfor %}
+
+    {% if svc.Backends.HealthCheck.Path %}
+    option httpchk {{ svc.Backends.HealthCheck.Method }} {{ svc.Backends.HealthCheck.Path }}
+    {% if svc.Backends.HealthCheck.ExpectStatus %}http-check expect status {{ svc.Backends.HealthCheck.ExpectStatus|integer }}{% endif %}
+    {% endif %}
+
     {% for e in svc.Backends.Endpoints %}
-    server {{ e.Name }} {{ e.IP }}:{{ e.Port }} {% if e.Weight %}weight {{ e.Weight|integer }} {% endif %} {% if Sticky %}cookie {{ e.Name }} {% endif %}
+    server {{ e.Name }} {{ e.IP }}:{{ e.Port }} {% if e.Weight %}weight {{ e.Weight|integer }} {% endif %} {% if svc.Backends.SessionAffinity.CookieName %}cookie {{ e.Name }} {% endif %} {% if svc.Backends.HealthCheck %}check {% if svc.Backends.HealthCheck.SSL and not svc.Backends.UpstreamTLS %}check-ssl verify none {% endif %}inter {{ svc.Backends.HealthCheck.Interval }} rise {{ svc.Backends.HealthCheck.Rise|integer }} fall {{ svc.Backends.HealthCheck.Fall|integer }}{% endif %}{% if svc.Backends.UpstreamTLS %} ssl {% if svc.Backends.UpstreamTLS.CAFile %}verify required ca-file {{ svc.Backends.UpstreamTLS.CAFile }} {% if svc.Backends.UpstreamTLS.VerifyHost %}verifyhost {{ svc.Backends.UpstreamTLS.VerifyHost }} {% endif %}{% else %}verify none {% endif %}{% if svc.Backends.UpstreamTLS.CrtFile %}crt {{ svc.Backends.UpstreamTLS.CrtFile }} {% endif %}{% if svc.Backends.UpstreamTLS.SNI %}sni str({{ svc.Backends.UpstreamTLS.SNI }}){% endif %}{% endif %}{% if svc.Backends.H2 %} {% if svc.Backends.UpstreamTLS %}alpn h2{% else %}proto h2{% endif %}{% endif %}{% if svc.Backends.SendProxy %} {{ svc.Backends.SendProxy }}{% if svc.Backends.HealthCheck %} check-send-proxy{% endif %}{% endif %}{% if e.Resolve %} resolvers cluster-dns resolve-prefer ipv4 init-addr last,libc,none{% endif %}
     {% endfor %}
+
+{% if svc.Backends.RateLimit %}
+backend http-{{ svc.Name }}-rate-limit
+    stick-table type ip size 100k expire 30s store conn_cur,http_req_rate(1s)
+{% endif %}
 {% endfor %}
 
 
-{% if TCPService %}
+{% if TCPFrontends %}
 # tcp service
-{% for svc in TCPService %}
-frontend tcp-frontend-key-{{ svc.Port }}
-    bind *:{{ svc.Port }} {% if svc.SecretName %}ssl no-sslv3 no-tlsv10 no-tls-tickets crt /etc/ssl/private/haproxy/{{ svc.SecretName }}.pem{% endif %} {%if svc.ALPNOptions %} {{svc.ALPNOptions}}{% endif %}
+{% for fe in TCPFrontends %}
+frontend tcp-frontend-key-{{ fe.Port }}
+    bind *:{{ fe.Port }}{% if AcceptProxy %} accept-proxy{% endif %} {% if fe.SecretNames %}ssl {{ TLS.BindOptions }}{% for secret in fe.SecretNames %} crt /etc/ssl/private/haproxy/{{ secret }}.pem{% endfor %}{% if fe.ClientCA %} ca-file {{ fe.ClientCA.CAFile }} {% if fe.ClientCA.CRLFile %}crl-file {{ fe.ClientCA.CRLFile }} {% endif %}verify optional ca-ignore-err all crt-ignore-err all{% endif %}{% endif %} {%if fe.ALPNOptions %} {{fe.ALPNOptions}}{% endif %}
     mode tcp
-    default_backend tcp-{{ svc.Name }}
+    {% if RateLimit and not fe.HTTPS %}
+    tcp-request connection track-sc0 src table rate-limit
+    {% if RateLimit.ConnectionsPerSecond %}tcp-request connection reject if { sc0_conn_rate gt {{ RateLimit.ConnectionsPerSecond|integer }} }{% endif %}
+    {% if RateLimit.Connections %}tcp-request connection reject if { sc0_conn_cur gt {{ RateLimit.Connections|integer }} }{% endif %}
+    {% endif %}
+    {% for r in fe.SourceRanges %}
+    {% if r.Whitelist %}
+    acl ___src_allow_{{ forloop.Counter }} src {{ r.Whitelist|join:" " }}
+    tcp-request connection reject if !___src_allow_{{ forloop.Counter }}
+    {% endif %}
+    {% if r.Blacklist %}
+    acl ___src_deny_{{ forloop.Counter }} src {{ r.Blacklist|join:" " }}
+    tcp-request connection reject if ___src_deny_{{ forloop.Counter }}
+    {% endif %}
+    {% endfor %}
+    {% if fe.SNI %}
+    tcp-request inspect-delay 5s
+    {% endif %}
+    {% for svc in fe.Services %}
+    acl ___sni_{{ svc.Name }} {% if fe.SecretNames %}{{ svc.Host|sni_acl:"ssl_fc_sni" }}{% else %}{{ svc.Host|sni_acl:"req.ssl_sni" }}{% endif %}
+    {% endfor %}
+    {% if RateLimit and fe.HTTPS and fe.Services %}
+    # connections passed to the https-frontend are counted there, only once
+    {% for svc in fe.Services %}
+    tcp-request content track-sc0 src table rate-limit if ___sni_{{ svc.Name }}
+    {% endfor %}
+    {% if RateLimit.ConnectionsPerSecond %}tcp-request content reject if { sc0_conn_rate gt {{ RateLimit.ConnectionsPerSecond|integer }} }{% endif %}
+    {% if RateLimit.Connections %}tcp-request content reject if { sc0_conn_cur gt {{ RateLimit.Connections|integer }} }{% endif %}
+    {% endif %}
+    {% if not fe.SourceRanges %}
+    {% for svc in fe.Services %}
+    {% for r in svc.SourceRanges %}
+    {% if r.Whitelist %}
+    acl ___src_allow_{{ svc.Name }}_{{ forloop.Counter }} src {{ r.Whitelist|join:" " }}
+    tcp-request content reject if !___src_allow_{{ svc.Name }}_{{ forloop.Counter }} ___sni_{{ svc.Name }}
+    {% endif %}
+    {% if r.Blacklist %}
+    acl ___src_deny_{{ svc.Name }}_{{ forloop.Counter }} src {{ r.Blacklist|join:" " }}
+    tcp-request content reject if ___src_deny_{{ svc.Name }}_{{ forloop.Counter }} ___sni_{{ svc.Name }}
+    {% endif %}
+    {% endfor %}
+    {% endfor %}
+    {% for r in fe.DefaultService.SourceRanges %}
+    {% if r.Whitelist %}
+    acl ___src_allow_{{ fe.DefaultService.Name }}_{{ forloop.Counter }} src {{ r.Whitelist|join:" " }}
+    tcp-request content reject if !___src_allow_{{ fe.DefaultService.Name }}_{{ forloop.Counter }}{% for svc in fe.Services %} !___sni_{{ svc.Name }}{% endfor %}
+    {% endif %}
+    {% if r.Blacklist %}
+    acl ___src_deny_{{ fe.DefaultService.Name }}_{{ forloop.Counter }} src {{ r.Blacklist|join:" " }}
+    tcp-request content reject if ___src_deny_{{ fe.DefaultService.Name }}_{{ forloop.Counter }}{% for svc in fe.Services %} !___sni_{{ svc.Name }}{% endfor %}
+    {% endif %}
+    {% endfor %}
+    {% endif %}
+    {% if fe.SNI %}
+    tcp-request content accept if { req.ssl_hello_type 1 }
+    {% endif %}
+    {% for svc in fe.Services %}
+    use_backend tcp-{{ svc.Name }} if ___sni_{{ svc.Name }}
+    {% endfor %}
+    {% if fe.HTTPS %}
+    {% for p in HttpsTLSProfiles %}{% for h in p.Hosts %}
+    use_backend {{ p.Name }} if { {{ h|sni_acl:"req.ssl_sni" }} }
+    {% endfor %}{% endfor %}
+    {% endif %}
+    {% if fe.HTTPS %}default_backend https-terminate{% elif fe.DefaultService %}default_backend tcp-{{ fe.DefaultService.Name }}{% endif %}
 {% endfor %}
 {% endif %}
 
+{% if HttpsPassthrough %}
+# terminates TLS of all other hosts
+backend https-terminate
+    mode tcp
+    server https-frontend abns@https-frontend send-proxy-v2
+{% endif %}
+{% for p in HttpsTLSProfiles %}
+# terminates TLS of {{ p.Hosts|join:", " }}
+backend {{ p.Name }}
+    mode tcp
+    server {{ p.Name }} abns@{{ p.Name }} send-proxy-v2
+{% endfor %}
+
 {% for svc in TCPService %}
 backend tcp-{{ svc.Name }}
     mode tcp
+    {% if svc.ClientAuth.Required %}
+    tcp-request content reject if !{ ssl_c_used }
+    tcp-request content reject if !{ ssl_c_verify 0 }
+    {% endif %}
+    {% if svc.Backends.Balance %}balance {{ svc.Backends.Balance }}{% endif %}
+    {% if svc.Backends.HashType %}hash-type {{ svc.Backends.HashType }}{% endif %}
+
+    {% if svc.Backends.RateLimit %}
+    tcp-request content track-sc1 src table tcp-{{ svc.Name }}-rate-limit
+    {% if svc.Backends.RateLimit.ConnectionsPerSecond %}tcp-request content reject if { sc1_conn_rate gt {{ svc.Backends.RateLimit.ConnectionsPerSecond|integer }} }{% endif %}
+    {% if svc.Backends.RateLimit.Connections %}tcp-request content reject if { sc1_conn_cur gt {{ svc.Backends.RateLimit.Connections|integer }} }{% endif %}
+    {% endif %}
 
     {% for rule in svc.Backends.BackendRules %}
     {{ rule }}
     {% endfor %}
 
-    {% if Sticky %}
-    stick-table type ip size 100k expire 30m
-    stick on src
+    {% if svc.Backends.SessionAffinity.StickOn %}
+    stick-table type {{ svc.Backends.SessionAffinity.StickTableType }} size 100k expire 30m
+    stick on {{ svc.Backends.SessionAffinity.StickOn }}
     {% endif %}
 
     {% for e in svc.Backends.Endpoints %}
-    server {{ e.Name }} {{ e.IP }}:{{ e.Port }} {% if e.Weight %}weight {{ e.Weight|integer }} {% endif %}
+    server {{ e.Name }} {{ e.IP }}:{{ e.Port }} {% if e.Weight %}weight {{ e.Weight|integer }} {% endif %} {% if svc.Backends.HealthCheck %}check {% if svc.Backends.HealthCheck.SSL and not svc.Backends.UpstreamTLS %}check-ssl verify none {% endif %}inter {{ svc.Backends.HealthCheck.Interval }} rise {{ svc.Backends.HealthCheck.Rise|integer }} fall {{ svc.Backends.HealthCheck.Fall|integer }}{% endif %}{% if svc.Backends.UpstreamTLS %} ssl {% if svc.Backends.UpstreamTLS.CAFile %}verify required ca-file {{ svc.Backends.UpstreamTLS.CAFile }} {% if svc.Backends.UpstreamTLS.VerifyHost %}verifyhost {{ svc.Backends.UpstreamTLS.VerifyHost }} {% endif %}{% else %}verify none {% endif %}{% if svc.Backends.UpstreamTLS.CrtFile %}crt {{ svc.Backends.UpstreamTLS.CrtFile }} {% endif %}{% if svc.Backends.UpstreamTLS.SNI %}sni str({{ svc.Backends.UpstreamTLS.SNI }}){% endif %}{% endif %}{% if svc.Backends.SendProxy %} {{ svc.Backends.SendProxy }}{% if svc.Backends.HealthCheck %} check-send-proxy{% endif %}{% endif %}{% if e.Resolve %} resolvers cluster-dns resolve-prefer ipv4 init-addr last,libc,none{% endif %}
     {% endfor %}
+
+{% if svc.Backends.RateLimit %}
+backend tcp-{{ svc.Name }}-rate-limit
+    stick-table type ip size 100k expire 30s store conn_cur,conn_rate(1s)
+{% endif %}
 {% endfor %}
 
-{% if !HttpService and !HttpsService and DefaultBackend %}
+{% if NoRoute %}
+# answers requests matching no route
+backend no-route
+    {% for f in NoRoute.ErrorFiles %}
+    errorfile {{ f.Code }} {{ f.Path }}
+    {% endfor %}
+    http-request deny deny_status {{ NoRoute.Status|integer }}
+{% endif %}
+
+{% if !HttpService and !HttpsService and !SSLRedirectHosts and (DefaultBackend or NoRoute) %}
 frontend http-frontend
-    bind *:80
+    bind *:80{% if AcceptProxy %} accept-proxy{% endif %}
     mode http
 
     option forwardfor
-    default_backend default-backend
+
+    {% if TrustedProxies %}
+    acl ___trusted_proxy src {{ TrustedProxies|join:" " }}
+    http-request set-src hdr_ip(X-Forwarded-For,-1) if ___trusted_proxy
+    {% endif %}
+    {% for r in SourceRanges %}
+    {% if r.Host %}acl ___src_host_{{ forloop.Counter }} {{ r.Host|host_name }}{% endif %}
+    {% if r.Whitelist %}
+    acl ___src_allow_{{ forloop.Counter }} src {{ r.Whitelist|join:" " }}
+    http-request deny if {% if r.Host %}___src_host_{{ forloop.Counter }} {% endif %}!___src_allow_{{ forloop.Counter }}
+    {% endif %}
+    {% if r.Blacklist %}
+    acl ___src_deny_{{ forloop.Counter }} src {{ r.Blacklist|join:" " }}
+    http-request deny if {% if r.Host %}___src_host_{{ forloop.Counter }} {% endif %}___src_deny_{{ forloop.Counter }}
+    {% endif %}
+    {% endfor %}
+    {% if RateLimit %}
+    http-request track-sc0 src table rate-limit
+    {% if RateLimit.RequestsPerSecond %}http-request {% if RateLimit.Tarpit %}tarpit{% else %}deny deny_status 429{% endif %} if { sc0_http_req_rate gt {{ RateLimit.RequestsPerSecond|integer }} }{% endif %}
+    {% if RateLimit.Connections %}http-request {% if RateLimit.Tarpit %}tarpit{% else %}deny deny_status 429{% endif %} if { sc0_conn_cur gt {{ RateLimit.Connections|integer }} }{% endif %}
+    {% endif %}
+
+    {% if DefaultBackend %}default_backend default-backend{% else %}default_backend no-route{% endif %}
 {% endif %}`
@@ -1,6 +1,7 @@
 package ingress
 
 import (
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -8,6 +9,7 @@ import (
 	aci "github.com/appscode/k8s-addons/api"
 	acs "github.com/appscode/k8s-addons/client/clientset"
 	"github.com/appscode/k8s-addons/pkg/stash"
+	"github.com/appscode/log"
 	"k8s.io/kubernetes/pkg/client/cache"
 	clientset "k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset"
 	"k8s.io/kubernetes/pkg/cloudprovider"
@@ -43,12 +45,125 @@ const (
 	LoadBalancerIP      = "ingress.appscode.com/ip"      // external_ip or loadbalancer_ip "" or a "ipv4"
 	LoadBalancerPersist = "ingress.appscode.com/persist" // "" or a "true"
 
+	// Expects a PROXY protocol header on the connections to the http, https and
+	// tcp ports, ie. behind an AWS ELB or another proxy. On aws the
+	// LoadBalancer is configured to send it.
+	AcceptProxy = "ingress.appscode.com/acceptProxy" // "" or a "true"
+
+	// ref: k8s.io/kubernetes/pkg/cloudprovider/providers/aws.ServiceAnnotationLoadBalancerProxyProtocol
+	awsProxyProtocolAnnotation = "service.beta.kubernetes.io/aws-load-balancer-proxy-protocol"
+
 	// LoadBalancerBackendWeightKey is the weight value of a Pod that was
 	// addressed by the Endpoint, this weight will be added to server backend.
 	// Traffic will be forwarded according to there weight.
 	LoadBalancerBackendWeight = "ingress.appscode.com/backend.weight"
+
+	// Maximum number of concurrent connections HAProxy accepts (default 4000)
+	MaxConn = "ingress.appscode.com/maxconn"
+
+	// HAProxy timeouts in HAProxy time format, ie. 50s, 5m or 50000 for
+	// milliseconds (default 50000 for all timeouts)
+	TimeoutConnect   = "ingress.appscode.com/timeout.connect"
+	TimeoutClient    = "ingress.appscode.com/timeout.client"
+	TimeoutClientFin = "ingress.appscode.com/timeout.clientFin"
+	TimeoutServer    = "ingress.appscode.com/timeout.server"
+	TimeoutTunnel    = "ingress.appscode.com/timeout.tunnel"
+
+	// HTTP connection mode, one of http-keep-alive, http-server-close or
+	// tunnel (default http-server-close)
+	ConnectionMode = "ingress.appscode.com/connectionMode"
+
+	// HTTP connection reuse across requests towards servers, one of never,
+	// safe, aggressive or always. Uses HAProxy default if unset.
+	HTTPReuse = "ingress.appscode.com/httpReuse"
+
+	// Redirect plain HTTP requests for hosts listed in TLS to HTTPS
+	SSLRedirect = "ingress.appscode.com/sslRedirect"
+	// Status code of the redirect, one of 301, 302 or 308 (default 301)
+	SSLRedirectCode = "ingress.appscode.com/sslRedirect.code"
+
+	// Comma separated client CIDRs allowed or denied to access all rules
+	WhitelistSourceRange = "ingress.appscode.com/whitelistSourceRange"
+	BlacklistSourceRange = "ingress.appscode.com/blacklistSourceRange"
+
+	// Comma separated CIDRs of trusted proxies in front of HAProxy. For
+	// requests from these, the client ip is taken from X-Forwarded-For.
+	TrustedProxies = "ingress.appscode.com/trustedProxies"
+
+	// Rate limits per client ip applied to all rules, see aci.RateLimit
+	RateLimitRequestsPerSecond    = "ingress.appscode.com/rateLimit.requestsPerSecond"
+	RateLimitConnectionsPerSecond = "ingress.appscode.com/rateLimit.connectionsPerSecond"
+	RateLimitConnections          = "ingress.appscode.com/rateLimit.connections"
+	RateLimitResponse             = "ingress.appscode.com/rateLimit.response"
+
+	// TLS settings of all TLS terminating binds, see aci.TLSOptions
+	TLSPreset         = "ingress.appscode.com/tls.preset"
+	TLSMinVersion     = "ingress.appscode.com/tls.minVersion"
+	TLSCiphers        = "ingress.appscode.com/tls.ciphers"
+	TLSCurves         = "ingress.appscode.com/tls.curves"
+	TLSSessionTickets = "ingress.appscode.com/tls.sessionTickets"
+	TLSDHParamSize    = "ingress.appscode.com/tls.dhParamSize"
+
+	// Offers HTTP/2 to clients of https hosts
+	HTTP2 = "ingress.appscode.com/http2"
+
+	// Strict-Transport-Security header of https responses, see aci.HSTS.
+	// Set hsts to false to disable it.
+	HSTSEnabled           = "ingress.appscode.com/hsts"
+	HSTSMaxAge            = "ingress.appscode.com/hsts.maxAge"
+	HSTSIncludeSubDomains = "ingress.appscode.com/hsts.includeSubDomains"
+	HSTSPreload           = "ingress.appscode.com/hsts.preload"
+
+	// Set to false to stop marking cookies of https responses as Secure
+	SecureCookies = "ingress.appscode.com/secureCookies"
+
+	// Name of a ConfigMap holding custom error pages of all backends, stored
+	// as <status code>.http keys, ie. 503.http
+	ErrorFiles = "ingress.appscode.com/errorFiles"
+
+	// Response to requests matching no rule if there is no default backend,
+	// a status code with a custom error page. Defaults to 503 if only the
+	// ConfigMap of the error page is set.
+	NoRouteStatus     = "ingress.appscode.com/noRoute.status"
+	NoRouteErrorFiles = "ingress.appscode.com/noRoute.errorFiles"
 )
 
+const (
+	defaultMaxConn         = 4000
+	defaultTimeout         = "50000"
+	defaultConnectionMode  = "http-server-close"
+	defaultSSLRedirectCode = 301
+	defaultHSTSMaxAge      = 15768000
+)
+
+// ErrorFiles ConfigMaps are mounted in the HAProxy pods under this directory.
+const errorFilesPath = "/srv/haproxy/errorfiles/"
+
+// Secrets of upstream TLS are mounted in the HAProxy pods under this
+// directory, outside of the certificates served by the frontends. On start
+// the pods join tls.crt and tls.key of each to upstreamCertsPath/<name>.pem.
+const (
+	upstreamSecretsPath = "/srv/haproxy/upstream/"
+	upstreamCertsPath   = "/etc/ssl/private/upstream/"
+)
+
+// Service of the cluster DNS, which resolves the host names of servers.
+const (
+	clusterDNSNamespace = "kube-system"
+	clusterDNSName      = "kube-dns"
+)
+
+// CA bundles of client certificate authentication are mounted in the
+// HAProxy pods under this directory.
+const clientCASecretsPath = "/srv/haproxy/client-ca/"
+
+// status codes HAProxy supports custom error pages for
+var errorFileCodes = []string{"200", "400", "403", "405", "408", "429", "500", "502", "503", "504"}
+
+var timeoutFormat = regexp.MustCompile(`^[0-9]+(us|ms|s|m|h|d)?$`)
+
+var healthCheckMethod = regexp.MustCompile(`^[A-Z]+$`)
+
 type annotation map[string]string
 
 func (s annotation) StickySession() bool {
@@ -66,6 +181,20 @@ func (s annotation) StatsSecretName() string {
 	return v
 }
 
+func (s annotation) ErrorFiles() string {
+	v, _ := s[ErrorFiles]
+	return v
+}
+
+func (s annotation) NoRouteStatus() int {
+	return s.positiveInt(NoRouteStatus)
+}
+
+func (s annotation) NoRouteErrorFiles() string {
+	v, _ := s[NoRouteErrorFiles]
+	return v
+}
+
 func (s annotation) LBType() string {
 	if v, ok := s[LBType]; ok {
 		return v
@@ -98,6 +227,148 @@ func (s annotation) LoadBalancerPersist() bool {
 	return strings.ToLower(v) == "true"
 }
 
+func (s annotation) AcceptProxy() bool {
+	v, _ := s[AcceptProxy]
+	return strings.ToLower(v) == "true"
+}
+
+func (s annotation) MaxConn() int {
+	if v, ok := s[MaxConn]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+		log.Warningln("Invalid value", v, "for", MaxConn, "using", defaultMaxConn)
+	}
+	return defaultMaxConn
+}
+
+func (s annotation) Timeout(key string) string {
+	if v, ok := s[key]; ok {
+		if timeoutFormat.MatchString(v) {
+			return v
+		}
+		log.Warningln("Invalid value", v, "for", key, "using", defaultTimeout)
+	}
+	return defaultTimeout
+}
+
+func (s annotation) ConnectionMode() string {
+	if v, ok := s[ConnectionMode]; ok {
+		switch v {
+		case "http-keep-alive", "http-server-close":
+			return v
+		case "tunnel":
+			return "http-tunnel"
+		}
+		log.Warningln("Invalid value", v, "for", ConnectionMode, "using", defaultConnectionMode)
+	}
+	return defaultConnectionMode
+}
+
+func (s annotation) HTTPReuse() string {
+	if v, ok := s[HTTPReuse]; ok {
+		switch v {
+		case "never", "safe", "aggressive", "always":
+			return v
+		}
+		log.Warningln("Invalid value", v, "for", HTTPReuse, "ignoring")
+	}
+	return ""
+}
+
+func (s annotation) SSLRedirect() bool {
+	v, _ := s[SSLRedirect]
+	return strings.ToLower(v) == "true"
+}
+
+func (s annotation) SSLRedirectCode() int {
+	if v, ok := s[SSLRedirectCode]; ok {
+		switch v {
+		case "301", "302", "308":
+			n, _ := strconv.Atoi(v)
+			return n
+		}
+		log.Warningln("Invalid value", v, "for", SSLRedirectCode, "using", defaultSSLRedirectCode)
+	}
+	return defaultSSLRedirectCode
+}
+
+func (s annotation) WhitelistSourceRange() []string {
+	return s.list(WhitelistSourceRange)
+}
+
+func (s annotation) BlacklistSourceRange() []string {
+	return s.list(BlacklistSourceRange)
+}
+
+func (s annotation) TrustedProxies() []string {
+	return s.list(TrustedProxies)
+}
+
+// RateLimit returns the ingress wide rate limit, nil if none is set.
+func (s annotation) RateLimit() *aci.RateLimit {
+	rl := &aci.RateLimit{
+		RequestsPerSecond:    s.positiveInt(RateLimitRequestsPerSecond),
+		ConnectionsPerSecond: s.positiveInt(RateLimitConnectionsPerSecond),
+		Connections:          s.positiveInt(RateLimitConnections),
+		Response:             s[RateLimitResponse],
+	}
+	if rl.RequestsPerSecond == 0 && rl.ConnectionsPerSecond == 0 && rl.Connections == 0 {
+		return nil
+	}
+	return rl
+}
+
+// TLSOptions returns the ingress wide TLS settings.
+func (s annotation) TLSOptions() *aci.TLSOptions {
+	opts := &aci.TLSOptions{
+		Preset:      s[TLSPreset],
+		MinVersion:  s[TLSMinVersion],
+		Ciphers:     s[TLSCiphers],
+		Curves:      s[TLSCurves],
+		DHParamSize: s.positiveInt(TLSDHParamSize),
+		HTTP2:       strings.ToLower(s[HTTP2]) == "true",
+	}
+	if v, ok := s[TLSSessionTickets]; ok {
+		opts.SessionTickets, _ = strconv.ParseBool(v)
+	}
+	return opts
+}
+
+// HSTS returns the ingress wide Strict-Transport-Security header settings.
+func (s annotation) HSTS() *aci.HSTS {
+	return &aci.HSTS{
+		Disabled:          strings.ToLower(s[HSTSEnabled]) == "false",
+		MaxAge:            s.positiveInt(HSTSMaxAge),
+		IncludeSubDomains: strings.ToLower(s[HSTSIncludeSubDomains]) == "true",
+		Preload:           strings.ToLower(s[HSTSPreload]) == "true",
+	}
+}
+
+func (s annotation) SecureCookies() bool {
+	return strings.ToLower(s[SecureCookies]) != "false"
+}
+
+func (s annotation) positiveInt(key string) int {
+	if v, ok := s[key]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+		log.Warningln("Invalid value", v, "for", key, "ignoring")
+	}
+	return 0
+}
+
+func (s annotation) list(key string) []string {
+	values := make([]string, 0)
+	for _, v := range strings.Split(s[key], ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
 type EngressController struct {
 	// kubernetes client
 	KubeClient        clientset.Interface
@@ -137,6 +408,15 @@ type KubeOptions struct {
 	// contains raw configMap data parsed from the cfg file.
 	ConfigData string
 
+	// ConfigMaps with error pages mounted in HAProxy pods.
+	ErrorFilesConfigMaps []string
+
+	// Secrets with CA bundles and client certificates of upstream TLS.
+	UpstreamTLSSecrets []string
+
+	// Secrets with CA bundles of client certificate authentication.
+	ClientCASecrets []string
+
 	// Ports contains all the ports needed to be opened for the ingress.
 	// Those ports will be used to open loadbalancer/firewall.
 	// So any interference with underlying endpoints will not cause network update.
@@ -147,6 +427,7 @@ type KubeOptions struct {
 	DaemonNodeSelector  map[string]string
 	LoadBalancerIP      string
 	LoadBalancerPersist bool
+	AcceptProxy         bool
 }
 
 func (o KubeOptions) SupportsLoadBalancerType() bool {
@@ -162,10 +443,65 @@ type HAProxyOptions struct {
 	// those options are get from annotations. applied globally
 	// in all the sections.
 
-	// stick requests to specified servers.
+	// stick requests to specified servers, unless the backend sets
+	// its own session affinity.
 	Sticky  bool
 	SSLCert bool
 
+	// global and defaults section tuning
+	MaxConn          int
+	TimeoutConnect   string
+	TimeoutClient    string
+	TimeoutClientFin string
+	TimeoutServer    string
+	TimeoutTunnel    string
+	ConnectionMode   string
+	HTTPReuse        string
+
+	// hosts redirected from http to https
+	SSLRedirectHosts []string
+	SSLRedirectCode  int
+
+	// https-frontend is reached through the tcp frontend on 443, which
+	// passes through TLS of some hosts or routes them to TLS profiles
+	HttpsPassthrough bool
+
+	// TLS settings of all TLS terminating binds, and of https hosts
+	// with their own settings
+	TLS              *TLSOptions
+	HttpsTLSProfiles []*TLSProfile
+
+	// Strict-Transport-Security headers of https hosts with their own
+	// setting first, the ingress wide one last
+	HSTS          []*HSTS
+	SecureCookies bool
+
+	// http, https and tcp binds expect a PROXY protocol header
+	AcceptProxy bool
+
+	// client certificate authentication of https hosts, all verified
+	// against a single CA set on the binds serving them
+	ClientAuth []*ClientAuth
+
+	// user lists used for basic auth
+	UserLists []*UserList
+
+	// client ip restrictions of http frontends, the ingress wide one first
+	SourceRanges   []*SourceRange
+	TrustedProxies []string
+
+	// rate limit per client ip of all frontends
+	RateLimit *RateLimit
+
+	// error pages of all backends
+	ErrorFiles []*ErrorFile
+
+	// response to requests matching no route without default backend
+	NoRoute *NoRoute
+
+	// resolve host names of servers at runtime, nil until looked up
+	Nameservers []string
+
 	// open up load balancer stats
 	Stats bool
 	// Basic auth to lb stats
@@ -175,14 +511,34 @@ type HAProxyOptions struct {
 	DefaultBackend *Backend
 	HttpsService   []*Service
 	HttpService    []*Service
+	HttpsFrontends []*HTTPFrontend
+	HttpFrontends  []*HTTPFrontend
 	TCPService     []*TCPService
+	TCPFrontends   []*TCPFrontend
 }
 
 type Service struct {
+	Name      string
+	AclMatch  string
+	PathMatch string
+	Matches   []string
+	Host      string
+	Port      string
+	Backends  *Backend
+	// position of the rule and path in the spec
+	index string
+}
+
+// HTTPFrontend listens on a port shared by http or https services. The
+// frontend on the default port keeps the plain http-frontend or
+// https-frontend name.
+type HTTPFrontend struct {
 	Name     string
-	AclMatch string
-	Host     string
-	Backends *Backend
+	Port     string
+	Services []*Service
+	// CA verifying client certificates, if a host of the port not bound
+	// through a TLS profile has client auth
+	ClientCA *ClientAuth
 }
 
 type TCPService struct {
@@ -193,6 +549,32 @@ type TCPService struct {
 	PEMName     string
 	Backends    *Backend
 	ALPNOptions string
+
+	SourceRanges []*SourceRange
+	ClientAuth   *ClientAuth
+	// position of the rule and port in the spec
+	index string
+}
+
+// TCPFrontend listens on a port shared by tcp services. Services are
+// routed by the TLS server name of their host, services without host are
+// the default.
+type TCPFrontend struct {
+	Port string
+	// certificates if TLS is terminated
+	SecretNames []string
+	ALPNOptions string
+	// inspect the TLS client hello to route by server name
+	SNI            bool
+	Services       []*TCPService
+	DefaultService *TCPService
+	// forward all other connections to the https-frontend
+	HTTPS bool
+	// CA verifying client certificates, if TLS is terminated
+	ClientCA *ClientAuth
+	// source ranges of the only service of the port, checked on connect.
+	// Otherwise the ranges of the services are checked per host.
+	SourceRanges []*SourceRange
 }
 
 type Backend struct {
@@ -201,8 +583,123 @@ type Backend struct {
 	// Deprecated
 	RewriteRules []string `json:"RewriteRules,omitempty"`
 	// Deprecated
-	HeaderRules []string    `json:"HeaderRules,omitempty"`
-	Endpoints   []*Endpoint `json:"Endpoints,omitempty"`
+	HeaderRules     []string         `json:"HeaderRules,omitempty"`
+	Endpoints       []*Endpoint      `json:"Endpoints,omitempty"`
+	HealthCheck     *HealthCheck     `json:"HealthCheck,omitempty"`
+	BasicAuth       *BasicAuth       `json:"BasicAuth,omitempty"`
+	RateLimit       *RateLimit       `json:"RateLimit,omitempty"`
+	ErrorFiles      []*ErrorFile     `json:"ErrorFiles,omitempty"`
+	Balance         string           `json:"Balance,omitempty"`
+	HashType        string           `json:"HashType,omitempty"`
+	SessionAffinity *SessionAffinity `json:"SessionAffinity,omitempty"`
+	UpstreamTLS     *UpstreamTLS     `json:"UpstreamTLS,omitempty"`
+	// speak h2 to the servers
+	H2 bool `json:"H2,omitempty"`
+	// send-proxy or send-proxy-v2
+	SendProxy string `json:"SendProxy,omitempty"`
+}
+
+type HealthCheck struct {
+	Method       string
+	Path         string
+	ExpectStatus int
+	Interval     string
+	Rise         int
+	Fall         int
+	SSL          bool
+}
+
+type BasicAuth struct {
+	UserList string
+	Realm    string
+}
+
+// SessionAffinity binds clients either by a cookie or by a stick table.
+type SessionAffinity struct {
+	CookieName    string
+	CookieMode    string
+	CookieMaxLife string
+
+	// stick table type and sample the table is keyed by
+	StickTableType string
+	StickOn        string
+}
+
+// TLSOptions are the settings of TLS terminating binds.
+type TLSOptions struct {
+	BindOptions string
+	Ciphers     string
+	DHParamSize int
+	ALPN        string
+}
+
+// TLSProfile terminates TLS of hosts with their own TLS options on a separate
+// bind of https-frontend, which the tcp frontend on 443 routes the hosts to.
+type TLSProfile struct {
+	Name       string
+	Hosts      []string
+	SecretName string
+	TLS        *TLSOptions
+	// CA verifying client certificates, if a host has client auth
+	ClientCA *ClientAuth
+}
+
+// HSTS is the Strict-Transport-Security header of the hosts, or of all hosts
+// without their own header if none are given. Value is empty if disabled.
+type HSTS struct {
+	Hosts []string
+	Value string
+}
+
+// ClientAuth verifies client certificates of the hosts, or of all hosts of
+// a frontend if none are given.
+type ClientAuth struct {
+	Hosts    []string
+	CAFile   string
+	CRLFile  string
+	Required bool
+}
+
+type UpstreamTLS struct {
+	CAFile     string
+	CrtFile    string
+	SNI        string
+	VerifyHost string
+}
+
+type ErrorFile struct {
+	Code string
+	Path string
+}
+
+type NoRoute struct {
+	Status     int
+	ErrorFiles []*ErrorFile
+}
+
+type RateLimit struct {
+	RequestsPerSecond    int
+	ConnectionsPerSecond int
+	Connections          int
+	Tarpit               bool
+}
+
+// SourceRange restricts the client ips of requests to Host, or of all
+// requests if Host is empty.
+type SourceRange struct {
+	Host      string
+	Whitelist []string
+	Blacklist []string
+}
+
+type UserList struct {
+	Name  string
+	Users []*User
+}
+
+type User struct {
+	Name     string
+	Password string
 }
 
 type Endpoint struct {
@@ -210,6 +707,8 @@ type Endpoint struct {
 	IP     string
 	Port   string
 	Weight int
+	// IP is a host name resolved through the nameservers
+	Resolve bool
 }
 
 // Loadbalancer image is an almost constant type.
@@ -62,6 +62,24 @@ func (lbc *EngressController) updateConfigMap() error {
 	return nil
 }
 
+// isConfigChanged checks whether the HAProxy config generated from the
+// current state differs from the deployed one.
+func (lbc *EngressController) isConfigChanged() bool {
+	err := lbc.parse()
+	if err != nil {
+		return true
+	}
+	err = lbc.generateTemplate()
+	if err != nil {
+		return true
+	}
+	cMap, err := lbc.KubeClient.Core().ConfigMaps(lbc.Config.Namespace).Get(VoyagerPrefix + lbc.Config.Name)
+	if err != nil {
+		return true
+	}
+	return cMap.Data["haproxy.cfg"] != lbc.Options.ConfigData
+}
+
 func (lbc *EngressController) recreatePods() error {
 	if lbc.Options.LBType == LBDaemon || lbc.Options.LBType == LBHostPort {
 		err := lbc.deleteHostPortPods()
@@ -122,6 +140,7 @@ func (lbc *EngressController) updateLBSvc() error {
 			})
 		}
 	}
+	lbc.setProxyProtocolAnnotation(svc)
 	svc, err = lbc.KubeClient.Core().Services(lbc.Config.Namespace).Update(svc)
 	if err != nil {
 		return errors.FromErr(err).Err()